@@ -0,0 +1,213 @@
+package dmv
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/tomsteele/dmv/oauth/jwt"
+)
+
+var (
+	appleAuthURL  = "https://appleid.apple.com/auth/authorize"
+	appleTokenURL = "https://appleid.apple.com/auth/token"
+	appleKeysURL  = "https://appleid.apple.com/auth/keys"
+	appleIssuer   = "https://appleid.apple.com"
+)
+
+// AppleOptions contains options for Sign in with Apple. ClientID is the
+// Services ID. ClientSecret is ignored, a new client secret is signed with
+// PrivateKey for each token request.
+type AppleOptions struct {
+	OAuth2Options
+	// The Apple developer team ID.
+	TeamID string
+	// The ID of the Sign in with Apple private key.
+	KeyID string
+	// The PEM encoded private key (.p8 file) downloaded from Apple.
+	PrivateKey []byte
+}
+
+// Apple stores the access and refresh tokens along with the users profile
+// from the verified id_token.
+type Apple struct {
+	OAuth2Result
+	IDToken string
+	Profile AppleProfile
+	// User is only sent by Apple the first time a user signs in to the
+	// application and is nil otherwise. Store the name if you need it.
+	User *AppleUser
+}
+
+// AppleProfile stores information about the user from the id_token.
+type AppleProfile struct {
+	Sub            string
+	Email          string
+	EmailVerified  bool
+	IsPrivateEmail bool
+}
+
+// AppleUser stores the user information posted to the callback on the
+// first login.
+type AppleUser struct {
+	Name struct {
+		FirstName string `json:"firstName"`
+		LastName  string `json:"lastName"`
+	} `json:"name"`
+	Email string `json:"email"`
+}
+
+// Identity returns the user as an Identity. Name is only set the first
+// time a user signs in, when User is not nil.
+func (a *Apple) Identity() *Identity {
+	id := &Identity{
+		Errors:        a.Errors,
+		ReturnTo:      a.ReturnTo,
+		Provider:      "apple",
+		Subject:       a.Profile.Sub,
+		Email:         a.Profile.Email,
+		EmailVerified: a.Profile.EmailVerified,
+		Claims:        profileClaims(a.RawProfile, a.Profile),
+	}
+	if a.User != nil {
+		id.Name = strings.TrimSpace(a.User.Name.FirstName + " " + a.User.Name.LastName)
+	}
+	return id
+}
+
+// AuthApple authenticates users using Sign in with Apple. Apple posts the
+// callback using response_mode=form_post, so the callback handler must be
+// registered with m.Post. After handling a callback request, the id_token is
+// verified against Apple's published keys and an Apple struct will be mapped
+// to the current request context.
+//
+// This function should be called twice in each application, once on the login
+// handler and once on the callback handler.
+//
+//     appleOpts := &dmv.AppleOptions{
+//         OAuth2Options: dmv.OAuth2Options{
+//             ClientID:    "com.example.web",
+//             RedirectURL: "https://host/auth/callback/apple",
+//             Scopes:      []string{"name", "email"},
+//         },
+//         TeamID:     "ABCDE12345",
+//         KeyID:      "XYZ987WXYZ",
+//         PrivateKey: p8KeyBytes,
+//     }
+//
+//     m.Get("/auth/apple", dmv.AuthApple(appleOpts))
+//     m.Post("/auth/callback/apple", dmv.AuthApple(appleOpts), func(a *dmv.Apple, req *http.Request, w http.ResponseWriter) {
+//         // Handle any errors.
+//         if len(a.Errors) > 0 {
+//             http.Error(w, "OAuth failure", http.StatusInternalServerError)
+//             return
+//         }
+//         // Do something in a database to create or find the user by the Apple subject.
+//         user := findOrCreateByAppleSub(a.Profile.Sub)
+//         if a.User != nil {
+//             user.Name = a.User.Name.FirstName + " " + a.User.Name.LastName
+//         }
+//         s.Set("userID", user.ID)
+//         http.Redirect(w, req, "/", http.StatusFound)
+//     })
+func AuthApple(opts *AppleOptions) martini.Handler {
+	return newApple(opts).handler()
+}
+
+// NewAuthApple is like AuthApple, but returns an error if opts are not
+// valid.
+func NewAuthApple(opts *AppleOptions) (martini.Handler, error) {
+	return newApple(opts).validHandler()
+}
+
+func newApple(opts *AppleOptions) *oauth2Handler {
+	o := *opts
+	o.AuthURL = appleAuthURL
+	o.TokenURL = appleTokenURL
+	keys := newJWKS(appleKeysURL, o.HTTPClient)
+	h := newOAuth2Handler("apple", &o.OAuth2Options, o.Validate(), func(r *http.Request) (oauth2User, profileFunc) {
+		a := &Apple{}
+		return a, func(*http.Client) error {
+			if u := r.FormValue("user"); u != "" {
+				user := &AppleUser{}
+				if err := json.Unmarshal([]byte(u), user); err != nil {
+					return err
+				}
+				a.User = user
+			}
+			a.IDToken = a.Token.Extra["id_token"]
+			claims := &struct {
+				Iss            string      `json:"iss"`
+				Aud            string      `json:"aud"`
+				Exp            int64       `json:"exp"`
+				Sub            string      `json:"sub"`
+				Email          string      `json:"email"`
+				EmailVerified  interface{} `json:"email_verified"`
+				IsPrivateEmail interface{} `json:"is_private_email"`
+			}{}
+			if err := keys.verify(a.IDToken, claims); err != nil {
+				return err
+			}
+			if claims.Iss != appleIssuer || claims.Aud != o.ClientID {
+				return errors.New("id_token was not issued by apple for this client")
+			}
+			if time.Unix(claims.Exp, 0).Before(time.Now()) {
+				return errors.New("id_token has expired")
+			}
+			decodeIDToken(a.IDToken, &a.RawProfile)
+			a.Profile = AppleProfile{
+				Sub:            claims.Sub,
+				Email:          claims.Email,
+				EmailVerified:  claimTrue(claims.EmailVerified),
+				IsPrivateEmail: claimTrue(claims.IsPrivateEmail),
+			}
+			return nil
+		}
+	})
+	h.formPost = true
+	h.clientSecret = func() (string, error) {
+		return appleClientSecret(&o)
+	}
+	return h
+}
+
+// Validate checks that ClientID, TeamID, KeyID, a usable PrivateKey and
+// either RedirectURL or RedirectFunc are set. ClientSecret is not used.
+func (opts *AppleOptions) Validate() error {
+	if err := opts.OAuth2Options.validate(); err != nil {
+		return err
+	}
+	if opts.TeamID == "" || opts.KeyID == "" {
+		return errors.New("TeamID and KeyID are required")
+	}
+	if _, err := appleClientSecret(opts); err != nil {
+		return errors.New("invalid PrivateKey: " + err.Error())
+	}
+	return nil
+}
+
+// appleClientSecret builds the ES256 signed JWT Apple requires as the
+// client secret.
+func appleClientSecret(opts *AppleOptions) (string, error) {
+	t := jwt.NewToken(opts.TeamID, "", opts.PrivateKey)
+	t.ClaimSet.Aud = appleIssuer
+	t.ClaimSet.Sub = opts.ClientID
+	t.Header.Algorithm = jwt.ES256
+	t.Header.KeyId = opts.KeyID
+	return t.Encode()
+}
+
+// claimTrue reports whether a boolean claim is true. Apple sends these
+// claims as either JSON booleans or the strings "true" and "false".
+func claimTrue(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package dmv
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppleFormPostCallback(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	p8 := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	enc := base64.RawURLEncoding.EncodeToString
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":            "https://appleid.apple.com",
+		"aud":            "com.example.web",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"sub":            "000123.abc",
+		"email":          "gopher@privaterelay.appleid.com",
+		"email_verified": "true",
+	})
+	signed := enc([]byte(`{"alg":"RS256","kid":"k1"}`)) + "." + enc(claims)
+	h := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, h[:])
+	idToken := signed + "." + enc(sig)
+
+	fakeProvider(t, map[string]interface{}{
+		"/token": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, secret, ok := r.BasicAuth()
+			if !ok {
+				secret = r.FormValue("client_secret")
+			}
+			if !strings.HasPrefix(secret, enc([]byte(`{"alg":"ES256"`))) {
+				http.Error(w, "bad client secret", http.StatusUnauthorized)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"access_token":"token","refresh_token":"refresh","id_token":"%s"}`, idToken)
+		}),
+		"/keys": fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"k1","n":"%s","e":"%s"}]}`,
+			enc(rsaKey.N.Bytes()), enc(big.NewInt(int64(rsaKey.E)).Bytes())),
+	}, map[*string]string{&appleTokenURL: "/token", &appleKeysURL: "/keys"})
+
+	appleOpts := &AppleOptions{
+		OAuth2Options: OAuth2Options{
+			ClientID:    "com.example.web",
+			RedirectURL: "https://localhost/auth/callback/apple",
+		},
+		TeamID:     "ABCDE12345",
+		KeyID:      "XYZ987WXYZ",
+		PrivateKey: p8,
+	}
+	var a *Apple
+	m := testMartini()
+	m.Get("/auth/apple", AuthApple(appleOpts))
+	m.Post("/auth/callback/apple", AuthApple(appleOpts), func(aa *Apple) {
+		a = aa
+	})
+
+	res := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/apple", nil)
+	m.ServeHTTP(res, r)
+	if !strings.Contains(res.HeaderMap.Get("Location"), "response_mode=form_post") {
+		t.Errorf("Login redirect is missing response_mode, got %s", res.HeaderMap.Get("Location"))
+	}
+
+	loc, _ := url.Parse(res.HeaderMap.Get("Location"))
+	form := url.Values{}
+	form.Set("code", "c0d3")
+	form.Set("state", loc.Query().Get("state"))
+	form.Set("user", `{"name":{"firstName":"Go","lastName":"Pher"},"email":"gopher@privaterelay.appleid.com"}`)
+	r, _ = http.NewRequest("POST", "/auth/callback/apple", bytes.NewBufferString(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(res.Result().Cookies()[0])
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if len(a.Errors) > 0 {
+		t.Fatal(a.Errors)
+	}
+	if a.Profile.Sub != "000123.abc" || !a.Profile.EmailVerified {
+		t.Errorf("Profile not mapped, got %+v", a.Profile)
+	}
+	if a.User == nil || a.User.Name.FirstName != "Go" {
+		t.Errorf("First login user not mapped, got %+v", a.User)
+	}
+}
@@ -5,17 +5,63 @@ import (
 	"github.com/go-martini/martini"
 	"net/http"
 	"strings"
+	"unicode/utf8"
 )
 
 // Basic stores a username and password from the Authorization header.
 type Basic struct {
 	Username string
 	Password string
+	// verified is set once BasicOptions.Verifier accepted the credentials.
+	verified bool
+}
+
+// Identity returns the user as an Identity with the username as Subject.
+// Unless BasicOptions.Verifier accepted the credentials its Errors include
+// ErrNotVerified.
+func (b *Basic) Identity() *Identity {
+	id := &Identity{Provider: "basic", Subject: b.Username}
+	if !b.verified {
+		id.Errors = []error{ErrNotVerified}
+	}
+	return id
+}
+
+// Verifier checks a username and password, for example against a user
+// database or an htpasswd file.
+type Verifier interface {
+	Verify(username, password string) bool
+}
+
+// VerifierFunc is an adapter to allow the use of ordinary functions as a
+// Verifier.
+type VerifierFunc func(username, password string) bool
+
+// Verify calls f(username, password).
+func (f VerifierFunc) Verify(username, password string) bool {
+	return f(username, password)
+}
+
+// BasicOptions are used to pass conditional arguments to AuthBasicWithOptions.
+type BasicOptions struct {
+	// The realm sent in the WWW-Authenticate challenge. Defaults to
+	// "Authorization Required".
+	Realm string
+	// If true the challenge includes charset="UTF-8" (RFC 7617 section 2.1)
+	// and credentials that are not valid UTF-8 are rejected.
+	UTF8 bool
+	// If set, credentials are checked with Verifier and FailBasic is called
+	// when they do not match.
+	Verifier Verifier
+	// If set with a Verifier, failed credentials are recorded and requests
+	// for a throttled username or client IP get a 429 with a Retry-After
+	// header without being verified.
+	Throttle *Throttle
 }
 
 // AuthBasic attempts to get a username and password from an Authorization header.
 // Basic is mapped to the current request context. BasicFail will be called if
-// there are errors, the header is empty, or a username or password is empty.
+// there are errors, the header is empty, or the scheme is not Basic.
 //
 //    m.Get("/protected", AuthBasic(), func(b *dmv.Basic, w http.ResponseWriter) {
 //        // Lookup user by b.Username
@@ -23,32 +69,116 @@ type Basic struct {
 //        // If not valid call dmv.FailBasic(w)
 //    })
 func AuthBasic() martini.Handler {
+	return AuthBasicWithOptions(&BasicOptions{})
+}
+
+// AuthBasicWithOptions is the same as AuthBasic but allows setting the realm
+// and charset of the challenge.
+//
+//    opts := &dmv.BasicOptions{Realm: "Admin", UTF8: true}
+//    m.Get("/protected", AuthBasicWithOptions(opts), func(b *dmv.Basic, w http.ResponseWriter) {
+//        // If not valid call dmv.FailBasicWithOptions(w, opts)
+//    })
+func AuthBasicWithOptions(opts *BasicOptions) martini.Handler {
 	return func(req *http.Request, w http.ResponseWriter, c martini.Context) {
-		b := &Basic{}
-		auth := req.Header.Get("Authorization")
-		if auth == "" {
-			FailBasic(w)
-			return
+		b, ok := parseBasic(req.Header.Get("Authorization"), opts.UTF8)
+		if ok && opts.Verifier != nil {
+			if opts.Throttle != nil && throttleBasic(w, req, opts.Throttle, b) {
+				return
+			}
+			ok = opts.Verifier.Verify(b.Username, b.Password)
+			b.verified = ok
+			if ok && opts.Throttle != nil {
+				opts.Throttle.Succeed(b.Username, opts.Throttle.ClientIP(req))
+			}
 		}
-		data, err := base64.StdEncoding.DecodeString(strings.Replace(auth, "Basic ", "", 1))
-		if err != nil {
-			FailBasic(w)
+		if !ok {
+			FailBasicWithOptions(w, opts)
 			return
 		}
-		parts := strings.Split(strings.Replace(string(data), "Basic ", "", 1), ":")
-		if len(parts) < 2 {
-			FailBasic(w)
-			return
-		}
-		b.Username = parts[0]
-		b.Password = parts[1]
-		c.Map(b)
+		mapWithIdentity(c, b)
+	}
+}
+
+// AuthBasicWithVerifier is the same as AuthBasic but checks the credentials
+// with v. FailBasic is called automatically if they do not match, so Basic is
+// only mapped for verified users.
+//
+//    v := dmv.VerifierFunc(func(username, password string) bool {
+//        // Lookup user by username and compare password.
+//    })
+//    m.Get("/protected", AuthBasicWithVerifier(v), func(b *dmv.Basic) {
+//        // b.Username has been verified.
+//    })
+func AuthBasicWithVerifier(v Verifier) martini.Handler {
+	return AuthBasicWithOptions(&BasicOptions{Verifier: v})
+}
+
+// throttleBasic writes a 429 response and returns true if b.Username or the
+// client IP is throttled. Errors from the ThrottleStore are written as a 500.
+func throttleBasic(w http.ResponseWriter, req *http.Request, t *Throttle, b *Basic) bool {
+	wait, err := t.Check(b.Username, t.ClientIP(req))
+	if err != nil {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return true
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+		return true
+	}
+	return false
+}
+
+// parseBasic parses the credentials of an Authorization header using the
+// Basic scheme as described in RFC 7617. The scheme is matched case
+// insensitively and the password is everything after the first colon.
+func parseBasic(auth string, requireUTF8 bool) (*Basic, bool) {
+	parts := strings.SplitN(strings.TrimSpace(auth), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Basic") {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return nil, false
 	}
+	if requireUTF8 && !utf8.Valid(data) {
+		return nil, false
+	}
+	creds := string(data)
+	i := strings.IndexByte(creds, ':')
+	if i < 0 {
+		return nil, false
+	}
+	return &Basic{Username: creds[:i], Password: creds[i+1:]}, true
 }
 
 // FailBasic writes the required response headers to prompt
 // for basic authentication.
 func FailBasic(w http.ResponseWriter) {
-	w.Header().Set("WWW-Authenticate", "Basic realm=\"Authorization Required\"")
+	FailBasicWithOptions(w, &BasicOptions{})
+}
+
+// FailBasicWithOptions writes the required response headers to prompt
+// for basic authentication using the realm and charset from opts.
+func FailBasicWithOptions(w http.ResponseWriter, opts *BasicOptions) {
+	w.Header().Set("WWW-Authenticate", basicChallenge(opts))
 	http.Error(w, "Not Authorized", http.StatusUnauthorized)
 }
+
+func basicChallenge(opts *BasicOptions) string {
+	realm := opts.Realm
+	if realm == "" {
+		realm = "Authorization Required"
+	}
+	challenge := "Basic realm=" + quoteParam(realm)
+	if opts.UTF8 {
+		challenge += `, charset="UTF-8"`
+	}
+	return challenge
+}
+
+// quoteParam returns s as a quoted-string for use in an auth-param.
+func quoteParam(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -35,3 +35,45 @@ func Test_BasicAuth(t *testing.T) {
 		t.Error("Auth failed, got: ", res.Body.String())
 	}
 }
+
+func Test_ParseBasic(t *testing.T) {
+	enc := base64.StdEncoding.EncodeToString
+	tests := []struct {
+		auth     string
+		utf8     bool
+		ok       bool
+		username string
+		password string
+	}{
+		{"Basic " + enc([]byte("gopher:go:lf")), false, true, "gopher", "go:lf"},
+		{"basic " + enc([]byte("gopher:golf")), false, true, "gopher", "golf"},
+		{"BASIC  " + enc([]byte("gopher:")), false, true, "gopher", ""},
+		{"Bearer " + enc([]byte("gopher:golf")), false, false, "", ""},
+		{"Basic " + enc([]byte("gopher")), false, false, "", ""},
+		{"Basic not-base64", false, false, "", ""},
+		{enc([]byte("gopher:golf")), false, false, "", ""},
+		{"Basic " + enc([]byte("g\xffpher:golf")), true, false, "", ""},
+		{"Basic " + enc([]byte("göpher:golf")), true, true, "göpher", "golf"},
+	}
+	for _, tt := range tests {
+		b, ok := parseBasic(tt.auth, tt.utf8)
+		if ok != tt.ok {
+			t.Errorf("parseBasic(%q) ok = %v, want %v", tt.auth, ok, tt.ok)
+			continue
+		}
+		if ok && (b.Username != tt.username || b.Password != tt.password) {
+			t.Errorf("parseBasic(%q) = %+v", tt.auth, b)
+		}
+	}
+}
+
+func Test_BasicAuthChallenge(t *testing.T) {
+	res := httptest.NewRecorder()
+	m := martini.Classic()
+	m.Get("/protected", AuthBasicWithOptions(&BasicOptions{Realm: "Admin", UTF8: true}), func() {})
+	r, _ := http.NewRequest("GET", "/protected", nil)
+	m.ServeHTTP(res, r)
+	if g, w := res.Header().Get("WWW-Authenticate"), `Basic realm="Admin", charset="UTF-8"`; g != w {
+		t.Errorf("WWW-Authenticate = %q, want %q", g, w)
+	}
+}
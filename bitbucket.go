@@ -0,0 +1,177 @@
+package dmv
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/tomsteele/dmv/oauth"
+)
+
+var (
+	bbAuthURL    = "https://bitbucket.org/site/oauth2/authorize"
+	bbTokenURL   = "https://bitbucket.org/site/oauth2/access_token"
+	bbProfileURL = "https://api.bitbucket.org/2.0/user"
+	bbEmailsURL  = "https://api.bitbucket.org/2.0/user/emails"
+)
+
+// Bitbucket stores the access and refresh tokens along with the users
+// profile. Bitbucket access tokens expire after two hours, use Refresh
+// to obtain a new one.
+type Bitbucket struct {
+	OAuth2Result
+	Expiry  time.Time
+	Profile BitbucketProfile
+}
+
+// BitbucketProfile stores information about the user from Bitbucket. Email
+// is the users primary confirmed email address.
+type BitbucketProfile struct {
+	UUID        string `json:"uuid"`
+	AccountID   string `json:"account_id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"-"`
+	HTMLURL     string `json:"-"`
+	Email       string `json:"-"`
+}
+
+// Identity returns the user as an Identity. Email is verified when set, as
+// only the primary confirmed address is used.
+func (bb *Bitbucket) Identity() *Identity {
+	return &Identity{
+		Errors:        bb.Errors,
+		ReturnTo:      bb.ReturnTo,
+		Provider:      "bitbucket",
+		Subject:       bb.Profile.UUID,
+		Email:         bb.Profile.Email,
+		EmailVerified: bb.Profile.Email != "",
+		Name:          bb.Profile.DisplayName,
+		AvatarURL:     bb.Profile.AvatarURL,
+		Claims:        profileClaims(bb.RawProfile, bb.Profile),
+	}
+}
+
+// AuthBitbucket authenticates users using Bitbucket Cloud and OAuth2.0. After
+// handling a callback request, requests are made to get the users Bitbucket
+// profile and primary email address and a Bitbucket struct will be mapped
+// to the current request context. The "account" and "email" scopes are
+// required.
+//
+// This function should be called twice in each application, once on the login
+// handler and once on the callback handler.
+//
+//     bbOpts := &dmv.OAuth2Options{
+//         ClientID:     "oauth_key",
+//         ClientSecret: "oauth_secret",
+//         RedirectURL:  "http://host:port/auth/callback/bitbucket",
+//         Scopes:       []string{"account", "email"},
+//     }
+//
+//     m.Get("/auth/bitbucket", dmv.AuthBitbucket(bbOpts))
+//     m.Get("/auth/callback/bitbucket", dmv.AuthBitbucket(bbOpts), func(bb *dmv.Bitbucket, req *http.Request, w http.ResponseWriter) {
+//         // Handle any errors.
+//         if len(bb.Errors) > 0 {
+//             http.Error(w, "OAuth failure", http.StatusInternalServerError)
+//             return
+//         }
+//         // Do something in a database to create or find the user by the Bitbucket uuid.
+//         user := findOrCreateByBitbucketUUID(bb.Profile.UUID)
+//         s.Set("userID", user.ID)
+//         http.Redirect(w, req, "/", http.StatusFound)
+//     })
+func AuthBitbucket(opts *OAuth2Options) martini.Handler {
+	return newBitbucket(opts).handler()
+}
+
+// NewAuthBitbucket is like AuthBitbucket, but returns an error if opts are
+// not valid.
+func NewAuthBitbucket(opts *OAuth2Options) (martini.Handler, error) {
+	return newBitbucket(opts).validHandler()
+}
+
+func newBitbucket(opts *OAuth2Options) *oauth2Handler {
+	o := *opts
+	o.AuthURL = bbAuthURL
+	o.TokenURL = bbTokenURL
+	return newOAuth2Handler("bitbucket", &o, o.Validate(), func(*http.Request) (oauth2User, profileFunc) {
+		bb := &Bitbucket{}
+		return bb, func(client *http.Client) error {
+			bb.Expiry = bb.Token.Expiry
+			profile := struct {
+				BitbucketProfile
+				Links struct {
+					Avatar struct {
+						Href string `json:"href"`
+					} `json:"avatar"`
+					HTML struct {
+						Href string `json:"href"`
+					} `json:"html"`
+				} `json:"links"`
+			}{}
+			raw, err := getProfile(client, bbProfileURL, &profile)
+			bb.RawProfile = raw
+			if err != nil {
+				return err
+			}
+			profile.AvatarURL = profile.Links.Avatar.Href
+			profile.HTMLURL = profile.Links.HTML.Href
+			emails := struct {
+				Values []struct {
+					Email     string `json:"email"`
+					Primary   bool   `json:"is_primary"`
+					Confirmed bool   `json:"is_confirmed"`
+				} `json:"values"`
+			}{}
+			if _, err := getProfile(client, bbEmailsURL, &emails); err != nil {
+				return err
+			}
+			for _, e := range emails.Values {
+				if e.Primary && e.Confirmed {
+					profile.Email = e.Email
+					break
+				}
+			}
+			bb.Profile = profile.BitbucketProfile
+			return nil
+		}
+	})
+}
+
+// Refresh uses the RefreshToken to obtain a new access token from
+// Bitbucket. AccessToken, RefreshToken, Expiry and Token are updated in
+// place.
+// opts should be the same options passed to AuthBitbucket.
+//
+//     if time.Now().After(bb.Expiry) {
+//         if err := bb.Refresh(bbOpts); err != nil {
+//             // The user must log in again.
+//         }
+//     }
+func (bb *Bitbucket) Refresh(opts *OAuth2Options) error {
+	transport := &oauth.Transport{
+		Config: &oauth.Config{
+			ClientId:     opts.ClientID,
+			ClientSecret: opts.ClientSecret,
+			TokenURL:     bbTokenURL,
+			HTTPClient:   opts.HTTPClient,
+			Retry:        opts.Retry,
+		},
+		Token: &oauth.Token{
+			AccessToken:  bb.AccessToken,
+			RefreshToken: bb.RefreshToken,
+			Expiry:       bb.Expiry,
+		},
+	}
+	if bb.Token != nil {
+		transport.Token.Extra = bb.Token.Extra
+	}
+	if err := transport.Refresh(); err != nil {
+		return err
+	}
+	bb.AccessToken = transport.Token.AccessToken
+	bb.RefreshToken = transport.Token.RefreshToken
+	bb.Expiry = transport.Token.Expiry
+	bb.Token = transport.Token
+	return nil
+}
@@ -0,0 +1,42 @@
+package dmv
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBitbucketProfileAndRefresh(t *testing.T) {
+	fakeProvider(t, map[string]interface{}{
+		"/token": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.FormValue("grant_type") == "refresh_token" {
+				fmt.Fprint(w, `{"access_token":"token2","refresh_token":"refresh2","expires_in":7200}`)
+				return
+			}
+			fmt.Fprint(w, `{"access_token":"token1","refresh_token":"refresh1","expires_in":7200}`)
+		}),
+		"/user":        `{"uuid":"{u1}","username":"gopher","links":{"avatar":{"href":"http://avatar"}}}`,
+		"/user/emails": `{"values":[{"email":"old@example.com","is_primary":false,"is_confirmed":true},{"email":"gopher@example.com","is_primary":true,"is_confirmed":true}]}`,
+	}, map[*string]string{&bbTokenURL: "/token", &bbProfileURL: "/user", &bbEmailsURL: "/user/emails"})
+
+	bbOpts := testOAuth2Options("bitbucket")
+	var bb *Bitbucket
+	serveCallback("bitbucket", AuthBitbucket(&bbOpts), func(b *Bitbucket) { bb = b })
+	if len(bb.Errors) > 0 {
+		t.Fatal(bb.Errors)
+	}
+	if bb.Profile.UUID != "{u1}" || bb.Profile.AvatarURL != "http://avatar" || bb.Profile.Email != "gopher@example.com" {
+		t.Errorf("Profile not mapped, got %+v", bb.Profile)
+	}
+	if bb.Expiry.Before(time.Now().Add(time.Hour)) {
+		t.Errorf("Expiry not set, got %v", bb.Expiry)
+	}
+	if err := bb.Refresh(&bbOpts); err != nil {
+		t.Fatal(err)
+	}
+	if bb.AccessToken != "token2" || bb.RefreshToken != "refresh2" {
+		t.Errorf("Token not refreshed, got %+v", bb)
+	}
+}
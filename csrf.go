@@ -0,0 +1,155 @@
+package dmv
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+)
+
+// ErrInvalidCSRFToken is added to Local.Errors when the CSRF token is
+// missing or does not match the cookie.
+var ErrInvalidCSRFToken = errors.New("CSRF token missing or invalid")
+
+// CSRFOptions are used to pass conditional arguments to NewCSRF.
+type CSRFOptions struct {
+	// The name of the cookie holding the token. Defaults to "dmv_csrf".
+	CookieName string
+	// The form or JSON field the token is submitted in. Defaults to
+	// "csrf_token".
+	FieldName string
+	// The header the token may be submitted in instead, for requests made
+	// with JavaScript. Defaults to "X-CSRF-Token".
+	HeaderName string
+	// The path of the cookie. Defaults to "/".
+	Path string
+	// If true the cookie is only sent over HTTPS. It is always set for
+	// requests received over TLS.
+	Secure bool
+	// The key tokens are signed with. If empty a random key is generated,
+	// so tokens are only valid in the process that issued them.
+	Key []byte
+	// If set, tokens are bound to the session returned for the request,
+	// such as the session ID, and are not valid with another session.
+	SessionID func(*http.Request) string
+}
+
+// CSRF protects login forms from cross-site request forgery using the
+// signed double-submit cookie pattern. A random value is stored in a
+// cookie and the token, the value signed with Key and bound to SessionID,
+// must be submitted with the form. A cross-site request cannot read the
+// cookie, and a cookie planted by another site, such as a sibling
+// subdomain, has no valid token.
+type CSRF struct {
+	opts CSRFOptions
+}
+
+// NewCSRF returns a CSRF using opts, which may be nil for the defaults.
+//
+//    csrf := dmv.NewCSRF(nil)
+//    m.Get("/login", func(w http.ResponseWriter, req *http.Request, r render.Render) {
+//        // Include the token in a hidden field named csrf_token.
+//        r.HTML(200, "login", csrf.Token(w, req))
+//    })
+//    m.Post("/login", dmv.AuthLocal(&dmv.LocalOptions{CSRF: csrf}), func(l *dmv.Local) {
+//        // l.Errors contains dmv.ErrInvalidCSRFToken if the check failed.
+//    })
+func NewCSRF(opts *CSRFOptions) *CSRF {
+	c := &CSRF{}
+	if opts != nil {
+		c.opts = *opts
+	}
+	if c.opts.CookieName == "" {
+		c.opts.CookieName = "dmv_csrf"
+	}
+	if c.opts.FieldName == "" {
+		c.opts.FieldName = "csrf_token"
+	}
+	if c.opts.HeaderName == "" {
+		c.opts.HeaderName = "X-CSRF-Token"
+	}
+	if c.opts.Path == "" {
+		c.opts.Path = "/"
+	}
+	if len(c.opts.Key) == 0 {
+		c.opts.Key = make([]byte, 32)
+		if _, err := rand.Read(c.opts.Key); err != nil {
+			panic(err)
+		}
+	}
+	return c
+}
+
+// Token returns the token for the form on the current page. The value from
+// the request cookie is reused if present, otherwise a new one is generated
+// and set as a cookie on w, so Token must be called before the response
+// body is written.
+func (c *CSRF) Token(w http.ResponseWriter, req *http.Request) string {
+	if value := c.cookie(req); value != "" {
+		return c.sign(req, value)
+	}
+	return c.Rotate(w, req)
+}
+
+// Rotate sets a new cookie on w and returns its token. AuthLocal calls it
+// after a successful login with a UserStore, call it after checking the
+// password otherwise, so a token issued before the login cannot be used
+// afterwards.
+func (c *CSRF) Rotate(w http.ResponseWriter, req *http.Request) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(b)
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.opts.CookieName,
+		Value:    value,
+		Path:     c.opts.Path,
+		HttpOnly: true,
+		Secure:   c.opts.Secure || req.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.sign(req, value)
+}
+
+// Verify checks submitted, or the token in the HeaderName header if
+// submitted is empty, against the cookie and session of req in constant
+// time.
+func (c *CSRF) Verify(req *http.Request, submitted string) error {
+	if submitted == "" {
+		submitted = req.Header.Get(c.opts.HeaderName)
+	}
+	value := c.cookie(req)
+	if value == "" || subtle.ConstantTimeCompare([]byte(c.sign(req, value)), []byte(submitted)) != 1 {
+		return ErrInvalidCSRFToken
+	}
+	return nil
+}
+
+// sign returns the token for the cookie value, which is the value and its
+// HMAC with the session of req.
+func (c *CSRF) sign(req *http.Request, value string) string {
+	session := ""
+	if c.opts.SessionID != nil {
+		session = c.opts.SessionID(req)
+	}
+	m := hmac.New(sha256.New, c.opts.Key)
+	m.Write([]byte(value + "!" + session))
+	return value + "." + base64.RawURLEncoding.EncodeToString(m.Sum(nil))
+}
+
+// cookie returns the value of the request cookie, or "" if it is missing
+// or malformed.
+func (c *CSRF) cookie(req *http.Request) string {
+	cookie, err := req.Cookie(c.opts.CookieName)
+	if err != nil {
+		return ""
+	}
+	if b, err := base64.RawURLEncoding.DecodeString(cookie.Value); err != nil || len(b) != 32 {
+		return ""
+	}
+	return cookie.Value
+}
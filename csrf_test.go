@@ -0,0 +1,123 @@
+package dmv
+
+import (
+	"bytes"
+	"github.com/go-martini/martini"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func Test_CSRFToken(t *testing.T) {
+	csrf := NewCSRF(nil)
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	token := csrf.Token(res, req)
+	cookies := res.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "dmv_csrf" || !strings.HasPrefix(token, cookies[0].Value+".") || !cookies[0].HttpOnly {
+		t.Fatalf("unexpected cookies %v for token %q", cookies, token)
+	}
+	req.AddCookie(cookies[0])
+	res = httptest.NewRecorder()
+	if again := csrf.Token(res, req); again != token || len(res.Result().Cookies()) != 0 {
+		t.Errorf("expected existing token to be reused, got %q", again)
+	}
+	if err := csrf.Verify(req, token); err != nil {
+		t.Errorf("expected token to verify, got %v", err)
+	}
+	if err := csrf.Verify(req, "nope"); err != ErrInvalidCSRFToken {
+		t.Errorf("expected ErrInvalidCSRFToken, got %v", err)
+	}
+	req.Header.Set("X-CSRF-Token", token)
+	if err := csrf.Verify(req, ""); err != nil {
+		t.Errorf("expected header token to verify, got %v", err)
+	}
+	if err := csrf.Verify(req, cookies[0].Value); err != ErrInvalidCSRFToken {
+		t.Errorf("expected the unsigned cookie value to fail, got %v", err)
+	}
+	if err := NewCSRF(nil).Verify(req, token); err != ErrInvalidCSRFToken {
+		t.Errorf("expected a token signed with another key to fail, got %v", err)
+	}
+	req, _ = http.NewRequest("POST", "/login", nil)
+	if err := csrf.Verify(req, token); err != ErrInvalidCSRFToken {
+		t.Errorf("expected missing cookie to fail, got %v", err)
+	}
+}
+
+func Test_CSRFSession(t *testing.T) {
+	csrf := NewCSRF(&CSRFOptions{SessionID: func(req *http.Request) string {
+		return req.Header.Get("X-Session")
+	}})
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	req.Header.Set("X-Session", "victim")
+	token := csrf.Token(res, req)
+	req.AddCookie(res.Result().Cookies()[0])
+	if err := csrf.Verify(req, token); err != nil {
+		t.Errorf("expected token to verify, got %v", err)
+	}
+	req.Header.Set("X-Session", "attacker")
+	if err := csrf.Verify(req, token); err != ErrInvalidCSRFToken {
+		t.Errorf("expected token of another session to fail, got %v", err)
+	}
+}
+
+func Test_AuthLocalCSRFRotate(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("golf"), bcrypt.MinCost)
+	store := NewMemoryUserStore()
+	store.Add("gopher", &LocalUser{ID: "1", Login: "gopher", Hash: string(hash)})
+	csrf := NewCSRF(nil)
+	m := martini.Classic()
+	m.Post("/login", AuthLocal(&LocalOptions{Store: store, CSRF: csrf}), func(l *Local) {})
+	res := httptest.NewRecorder()
+	token := csrf.Token(res, &http.Request{})
+	cookie := res.Result().Cookies()[0]
+	data := url.Values{"username": {"gopher"}, "password": {"golf"}, "csrf_token": {token}}
+	res = httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(data.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.AddCookie(cookie)
+	m.ServeHTTP(res, req)
+	if cookies := res.Result().Cookies(); len(cookies) != 1 || cookies[0].Value == cookie.Value {
+		t.Errorf("expected the CSRF cookie to be rotated after login, got %v", cookies)
+	}
+}
+
+func Test_AuthLocalCSRF(t *testing.T) {
+	csrf := NewCSRF(nil)
+	m := martini.Classic()
+	m.Post("/login", AuthLocal(&LocalOptions{CSRF: csrf}), func(l *Local) string {
+		if len(l.Errors) > 0 {
+			return l.Errors[0].Error()
+		}
+		return "ok"
+	})
+	res := httptest.NewRecorder()
+	token := csrf.Token(res, &http.Request{})
+	cookie := res.Result().Cookies()[0]
+	for _, tt := range []struct {
+		token  string
+		cookie bool
+		body   string
+	}{
+		{token, true, "ok"},
+		{token, false, ErrInvalidCSRFToken.Error()},
+		{"", true, ErrInvalidCSRFToken.Error()},
+		{token[1:], true, ErrInvalidCSRFToken.Error()},
+	} {
+		data := url.Values{"username": {"gopher"}, "password": {"golf"}, "csrf_token": {tt.token}}
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(data.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if tt.cookie {
+			req.AddCookie(cookie)
+		}
+		m.ServeHTTP(res, req)
+		if res.Body.String() != tt.body {
+			t.Errorf("token %q cookie %v: got %q, want %q", tt.token, tt.cookie, res.Body.String(), tt.body)
+		}
+	}
+}
@@ -0,0 +1,315 @@
+package dmv
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+// Digest stores the verified username from a Digest Authorization header.
+type Digest struct {
+	Username  string
+	Realm     string
+	Algorithm string
+	// verified is set once the response was checked against the HA1.
+	verified bool
+}
+
+// Identity returns the user as an Identity with the username as Subject.
+// Unless the response was checked against DigestOptions.HA1 its Errors
+// include ErrNotVerified.
+func (d *Digest) Identity() *Identity {
+	id := &Identity{Provider: "digest", Subject: d.Username}
+	if !d.verified {
+		id.Errors = []error{ErrNotVerified}
+	}
+	return id
+}
+
+// DigestOptions are used to pass conditional arguments to AuthDigest.
+type DigestOptions struct {
+	// The realm sent in the WWW-Authenticate challenge. Defaults to
+	// "Authorization Required".
+	Realm string
+	// HA1 returns H(username:realm:password) for the user using the hash
+	// of algorithm, which is "SHA-256" or "MD5". ok should be false if the
+	// user does not exist. Use DigestHA1 to compute the stored value.
+	HA1 func(username, realm, algorithm string) (ha1 string, ok bool)
+	// The algorithms offered to the client, in order of preference.
+	// Defaults to SHA-256 and MD5.
+	Algorithms []string
+	// How long a nonce is valid before the client is re-challenged with
+	// stale=true. Defaults to five minutes.
+	NonceExpiry time.Duration
+}
+
+// DigestHA1 computes H(username:realm:password) for algorithm, which is
+// "SHA-256" or "MD5". Store this instead of the password.
+func DigestHA1(algorithm, username, realm, password string) string {
+	return digestHash(algorithm, username+":"+realm+":"+password)
+}
+
+// AuthDigest authenticates requests using HTTP Digest authentication as
+// described in RFC 7616 with qop=auth. Nonces are generated by the server,
+// expire after NonceExpiry and each nonce count may only be used once. On
+// success a Digest is mapped to the current request context, otherwise a
+// 401 with a new challenge is written.
+//
+//    opts := &dmv.DigestOptions{
+//        Realm: "devices",
+//        HA1: func(username, realm, algorithm string) (string, bool) {
+//            // Lookup the stored HA1 for username and algorithm.
+//        },
+//    }
+//    m.Get("/protected", dmv.AuthDigest(opts), func(d *dmv.Digest) string {
+//        return "hi " + d.Username
+//    })
+func AuthDigest(opts *DigestOptions) martini.Handler {
+	o := *opts
+	opts = &o
+	if opts.Realm == "" {
+		opts.Realm = "Authorization Required"
+	}
+	if len(opts.Algorithms) == 0 {
+		opts.Algorithms = []string{"SHA-256", "MD5"}
+	}
+	if opts.NonceExpiry == 0 {
+		opts.NonceExpiry = 5 * time.Minute
+	}
+	nonces := newDigestNonces(opts.NonceExpiry)
+
+	return func(req *http.Request, w http.ResponseWriter, c martini.Context) {
+		d, stale := verifyDigest(req, opts, nonces)
+		if d == nil {
+			failDigest(w, opts, nonces, stale)
+			return
+		}
+		mapWithIdentity(c, d)
+	}
+}
+
+// verifyDigest returns the verified Digest, or nil and whether the failure
+// was only caused by a stale nonce.
+func verifyDigest(req *http.Request, opts *DigestOptions, nonces *digestNonces) (*Digest, bool) {
+	auth := strings.TrimSpace(req.Header.Get("Authorization"))
+	parts := strings.SplitN(auth, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Digest") {
+		return nil, false
+	}
+	p := parseAuthParams(parts[1])
+	algorithm := p["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	if !containsString(opts.Algorithms, algorithm) || p["realm"] != opts.Realm ||
+		p["qop"] != "auth" || p["uri"] != req.RequestURI || p["username"] == "" {
+		return nil, false
+	}
+	if opts.HA1 == nil {
+		return nil, false
+	}
+	ha1, ok := opts.HA1(p["username"], opts.Realm, algorithm)
+	if !ok {
+		// Check the response against a dummy HA1, so unknown users take as
+		// long to reject as known ones.
+		ha1 = DigestHA1(algorithm, p["username"], opts.Realm, dummyPassword)
+	}
+	ha2 := digestHash(algorithm, req.Method+":"+p["uri"])
+	expected := digestHash(algorithm, strings.Join([]string{ha1, p["nonce"], p["nc"], p["cnonce"], p["qop"], ha2}, ":"))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(strings.ToLower(p["response"]))) != 1 || !ok {
+		return nil, false
+	}
+	// Only check the nonce once the response is known to be valid, so
+	// stale is never sent to a client with the wrong password.
+	nc, err := strconv.ParseUint(p["nc"], 16, 32)
+	if err != nil {
+		return nil, false
+	}
+	if valid, stale := nonces.use(p["nonce"], nc); !valid {
+		return nil, stale
+	}
+	return &Digest{Username: p["username"], Realm: opts.Realm, Algorithm: algorithm, verified: true}, false
+}
+
+func failDigest(w http.ResponseWriter, opts *DigestOptions, nonces *digestNonces, stale bool) {
+	nonce := nonces.issue()
+	for _, algorithm := range opts.Algorithms {
+		challenge := "Digest realm=" + quoteParam(opts.Realm) +
+			`, qop="auth", algorithm=` + algorithm +
+			", nonce=" + quoteParam(nonce)
+		if stale {
+			challenge += ", stale=true"
+		}
+		w.Header().Add("WWW-Authenticate", challenge)
+	}
+	http.Error(w, "Not Authorized", http.StatusUnauthorized)
+}
+
+func digestHash(algorithm, s string) string {
+	var h hash.Hash
+	if algorithm == "SHA-256" {
+		h = sha256.New()
+	} else {
+		h = md5.New()
+	}
+	h.Write([]byte(s))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// parseAuthParams parses a comma separated list of auth-params, values may
+// be tokens or quoted-strings.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if s == "" {
+			return params
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			return params
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " \t")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			var b strings.Builder
+			i := 1
+			for ; i < len(s) && s[i] != '"'; i++ {
+				if s[i] == '\\' && i+1 < len(s) {
+					i++
+				}
+				b.WriteByte(s[i])
+			}
+			value = b.String()
+			if i < len(s) {
+				i++
+			}
+			s = s[i:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		params[key] = value
+	}
+}
+
+// maxDigestNonces is the most nonces whose counts are tracked at once.
+// When the limit is reached the nonce first used longest ago is dropped,
+// and untracked nonces issued up to then are answered with a stale
+// challenge.
+const maxDigestNonces = 100000
+
+// digestNonces issues nonces and tracks the highest nonce count used with
+// each one to prevent replays. A nonce is the issue time and random bytes,
+// authenticated with an HMAC so expired nonces can be recognized as stale.
+// Nothing is stored when a nonce is issued, only once it is first used for
+// a request with a valid response.
+type digestNonces struct {
+	key    []byte
+	expiry time.Duration
+	mu     sync.Mutex
+	counts map[string]uint64
+	// order holds the tracked nonces in the order they were first used,
+	// so the oldest can be dropped without scanning counts.
+	order []usedNonce
+	// dropped is the latest issue time of a nonce dropped before it
+	// expired. Nonces issued until then are only valid while tracked.
+	dropped time.Time
+}
+
+type usedNonce struct {
+	nonce  string
+	issued time.Time
+}
+
+func newDigestNonces(expiry time.Duration) *digestNonces {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return &digestNonces{key: key, expiry: expiry, counts: make(map[string]uint64)}
+}
+
+func (n *digestNonces) mac(b []byte) []byte {
+	m := hmac.New(sha256.New, n.key)
+	m.Write(b)
+	return m.Sum(nil)[:16]
+}
+
+func (n *digestNonces) issue() string {
+	b := make([]byte, 24)
+	binary.BigEndian.PutUint64(b, uint64(time.Now().UnixNano()))
+	if _, err := rand.Read(b[8:]); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(append(b, n.mac(b)...))
+}
+
+// use records nc for nonce. It returns false if the nonce was not issued by
+// n, or nc has already been used, and stale if the nonce is authentic but
+// expired or issued before a nonce that was dropped.
+func (n *digestNonces) use(nonce string, nc uint64) (valid, stale bool) {
+	b, err := base64.RawURLEncoding.DecodeString(nonce)
+	if err != nil || len(b) != 40 || !hmac.Equal(b[24:], n.mac(b[:24])) {
+		return false, false
+	}
+	issued := time.Unix(0, int64(binary.BigEndian.Uint64(b)))
+	if time.Since(issued) > n.expiry {
+		return false, true
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.expire()
+	last, ok := n.counts[nonce]
+	if ok && nc <= last {
+		return false, false
+	}
+	if !ok {
+		if !issued.After(n.dropped) {
+			return false, true
+		}
+		if len(n.order) >= maxDigestNonces {
+			n.drop()
+		}
+		n.order = append(n.order, usedNonce{nonce, issued})
+	}
+	n.counts[nonce] = nc
+	return true, false
+}
+
+// expire removes the nonces first used longest ago while they are expired.
+// n.mu must be held.
+func (n *digestNonces) expire() {
+	for len(n.order) > 0 && time.Since(n.order[0].issued) > n.expiry {
+		delete(n.counts, n.order[0].nonce)
+		n.order = n.order[1:]
+	}
+}
+
+// drop removes the nonce first used longest ago. n.mu must be held.
+func (n *digestNonces) drop() {
+	u := n.order[0]
+	delete(n.counts, u.nonce)
+	n.order = n.order[1:]
+	if u.issued.After(n.dropped) {
+		n.dropped = u.issued
+	}
+}
@@ -0,0 +1,116 @@
+package dmv
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+func digestAuthorization(challenge, method, uri, username, password, nc string) string {
+	p := parseAuthParams(strings.TrimPrefix(challenge, "Digest "))
+	ha1 := DigestHA1(p["algorithm"], username, p["realm"], password)
+	ha2 := digestHash(p["algorithm"], method+":"+uri)
+	response := digestHash(p["algorithm"], strings.Join([]string{ha1, p["nonce"], nc, "c0ffee", "auth", ha2}, ":"))
+	return fmt.Sprintf(`Digest username="%s", realm="%s", uri="%s", algorithm=%s, nonce="%s", nc=%s, cnonce="c0ffee", qop=auth, response="%s"`,
+		username, p["realm"], uri, p["algorithm"], p["nonce"], nc, response)
+}
+
+func Test_AuthDigest(t *testing.T) {
+	opts := &DigestOptions{
+		Realm: "devices@example.com",
+		HA1: func(username, realm, algorithm string) (string, bool) {
+			if username != "gopher" {
+				return "", false
+			}
+			return DigestHA1(algorithm, username, realm, "golf"), true
+		},
+	}
+	m := martini.Classic()
+	m.Get("/protected", AuthDigest(opts), func(d *Digest) string {
+		return "hi " + d.Username + " " + d.Algorithm
+	})
+	if opts.Algorithms != nil || opts.NonceExpiry != 0 {
+		t.Error("AuthDigest set defaults on the callers options")
+	}
+	serve := func(auth string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/protected", nil)
+		r.RequestURI = "/protected"
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		m.ServeHTTP(res, r)
+		return res
+	}
+
+	res := serve("")
+	challenges := res.HeaderMap["Www-Authenticate"]
+	if res.Code != 401 || len(challenges) != 2 || !strings.Contains(challenges[0], "algorithm=SHA-256") {
+		t.Fatalf("Expected SHA-256 and MD5 challenges, got %d %v", res.Code, challenges)
+	}
+	for i, challenge := range challenges {
+		// Both challenges share a nonce, so the nonce count increases.
+		res = serve(digestAuthorization(challenge, "GET", "/protected", "gopher", "golf", fmt.Sprintf("%08x", i+1)))
+		if res.Code != 200 || !strings.HasPrefix(res.Body.String(), "hi gopher") {
+			t.Errorf("Valid credentials rejected for %s: %d", challenge, res.Code)
+		}
+	}
+
+	auth := digestAuthorization(challenges[0], "GET", "/protected", "gopher", "golf", "00000003")
+	if res = serve(auth); res.Code != 200 {
+		t.Errorf("Increasing nonce count rejected: %d", res.Code)
+	}
+	if res = serve(auth); res.Code != 401 {
+		t.Error("Replayed nonce count accepted")
+	}
+	if res = serve(digestAuthorization(challenges[0], "GET", "/protected", "gopher", "gulf", "00000004")); res.Code != 401 {
+		t.Error("Wrong password accepted")
+	}
+	if strings.Contains(res.HeaderMap.Get("Www-Authenticate"), "stale=true") {
+		t.Error("Wrong password should not be stale")
+	}
+	if res = serve(digestAuthorization(challenges[0], "GET", "/protected", "rustacean", dummyPassword, "00000005")); res.Code != 401 {
+		t.Error("Unknown user accepted with the dummy password")
+	}
+
+	opts.NonceExpiry = time.Nanosecond
+	expired := AuthDigest(opts)
+	m = martini.Classic()
+	m.Get("/protected", expired, func(d *Digest) {})
+	challenge := serve("").HeaderMap.Get("Www-Authenticate")
+	res = serve(digestAuthorization(challenge, "GET", "/protected", "gopher", "golf", "00000001"))
+	if res.Code != 401 || !strings.Contains(res.HeaderMap.Get("Www-Authenticate"), "stale=true") {
+		t.Errorf("Expired nonce should be stale, got %d %v", res.Code, res.HeaderMap["Www-Authenticate"])
+	}
+}
+
+func Test_DigestNonces(t *testing.T) {
+	n := newDigestNonces(time.Minute)
+	nonce := n.issue()
+	for i := 0; i < 10; i++ {
+		n.issue()
+	}
+	if len(n.counts) != 0 {
+		t.Fatalf("Issuing should not store nonces, got %d", len(n.counts))
+	}
+	if valid, _ := n.use(nonce, 1); !valid || len(n.counts) != 1 {
+		t.Errorf("First use rejected or not stored, got %v %d", valid, len(n.counts))
+	}
+	if valid, stale := n.use(nonce, 1); valid || stale {
+		t.Errorf("Replay should be invalid and not stale, got %v %v", valid, stale)
+	}
+	for len(n.counts) < maxDigestNonces {
+		n.use(n.issue(), 1)
+	}
+	if valid, _ := n.use(n.issue(), 1); !valid || len(n.counts) != maxDigestNonces {
+		t.Errorf("New nonce should replace the oldest once the limit is reached, got %v %d", valid, len(n.counts))
+	}
+	if valid, stale := n.use(nonce, 2); valid || !stale {
+		t.Errorf("Dropped nonce should be stale, got %v %v", valid, stale)
+	}
+}
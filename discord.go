@@ -0,0 +1,163 @@
+package dmv
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-martini/martini"
+)
+
+var (
+	discordAuthURL    = "https://discord.com/oauth2/authorize"
+	discordTokenURL   = "https://discord.com/api/oauth2/token"
+	discordProfileURL = "https://discord.com/api/users/@me"
+	discordGuildsURL  = "https://discord.com/api/users/@me/guilds"
+)
+
+// discordGuildsPage is the most guilds Discord returns per request.
+const discordGuildsPage = 200
+
+// DiscordOptions contains options for authenticating with Discord.
+type DiscordOptions struct {
+	OAuth2Options
+	// If not empty, only members of the server with this guild ID are
+	// allowed to log in. Requires the "guilds" scope.
+	GuildID string
+}
+
+// Discord stores the access and refresh tokens along with the users profile.
+type Discord struct {
+	OAuth2Result
+	Profile DiscordProfile
+}
+
+// DiscordProfile stores information about the user from Discord.
+type DiscordProfile struct {
+	ID            string `json:"id"`
+	Username      string `json:"username"`
+	GlobalName    string `json:"global_name"`
+	Discriminator string `json:"discriminator"`
+	Avatar        string `json:"avatar"`
+	Email         string `json:"email"`
+	Verified      bool   `json:"verified"`
+}
+
+// Identity returns the user as an Identity.
+func (d *Discord) Identity() *Identity {
+	id := &Identity{
+		Errors:        d.Errors,
+		ReturnTo:      d.ReturnTo,
+		Provider:      "discord",
+		Subject:       d.Profile.ID,
+		Email:         d.Profile.Email,
+		EmailVerified: d.Profile.Email != "" && d.Profile.Verified,
+		Name:          d.Profile.GlobalName,
+		Claims:        profileClaims(d.RawProfile, d.Profile),
+	}
+	if id.Name == "" {
+		id.Name = d.Profile.Username
+	}
+	if d.Profile.Avatar != "" {
+		id.AvatarURL = "https://cdn.discordapp.com/avatars/" + d.Profile.ID + "/" + d.Profile.Avatar + ".png"
+	}
+	return id
+}
+
+// AuthDiscord authenticates users using Discord and OAuth2.0. After handling
+// a callback request, a request is made to get the users Discord profile
+// and a Discord struct will be mapped to the current request context. If no
+// scopes are set the "identify", "email" and "guilds" scopes are requested.
+//
+// This function should be called twice in each application, once on the login
+// handler and once on the callback handler.
+//
+//     discordOpts := &dmv.DiscordOptions{
+//         OAuth2Options: dmv.OAuth2Options{
+//             ClientID:     "oauth_id",
+//             ClientSecret: "oauth_secret",
+//             RedirectURL:  "http://host:port/auth/callback/discord",
+//         },
+//         GuildID: "81384788765712384",
+//     }
+//
+//     m.Get("/auth/discord", dmv.AuthDiscord(discordOpts))
+//     m.Get("/auth/callback/discord", dmv.AuthDiscord(discordOpts), func(d *dmv.Discord, req *http.Request, w http.ResponseWriter) {
+//         // Handle any errors.
+//         if len(d.Errors) > 0 {
+//             http.Error(w, "OAuth failure", http.StatusInternalServerError)
+//             return
+//         }
+//         // Do something in a database to create or find the user by the Discord profile id.
+//         user := findOrCreateByDiscordID(d.Profile.ID)
+//         s.Set("userID", user.ID)
+//         http.Redirect(w, req, "/", http.StatusFound)
+//     })
+func AuthDiscord(opts *DiscordOptions) martini.Handler {
+	return newDiscord(opts).handler()
+}
+
+// NewAuthDiscord is like AuthDiscord, but returns an error if opts are not
+// valid.
+func NewAuthDiscord(opts *DiscordOptions) (martini.Handler, error) {
+	return newDiscord(opts).validHandler()
+}
+
+func newDiscord(opts *DiscordOptions) *oauth2Handler {
+	o := *opts
+	o.AuthURL = discordAuthURL
+	o.TokenURL = discordTokenURL
+	if len(o.Scopes) == 0 {
+		o.Scopes = []string{"identify", "email", "guilds"}
+	}
+	return newOAuth2Handler("discord", &o.OAuth2Options, o.Validate(), func(*http.Request) (oauth2User, profileFunc) {
+		d := &Discord{}
+		return d, func(client *http.Client) error {
+			profile := DiscordProfile{}
+			raw, err := getProfile(client, discordProfileURL, &profile)
+			d.RawProfile = raw
+			if err != nil {
+				return err
+			}
+			if o.GuildID != "" {
+				member, err := discordMember(client, o.GuildID)
+				if err != nil {
+					return err
+				}
+				if !member {
+					return errors.New("user is not a member of the discord guild")
+				}
+			}
+			d.Profile = profile
+			return nil
+		}
+	})
+}
+
+// discordMember reports whether the user is a member of guild, paging
+// through their guilds as Discord returns at most 200 at a time.
+func discordMember(client *http.Client, guild string) (bool, error) {
+	after := ""
+	for {
+		var guilds []struct {
+			ID string `json:"id"`
+		}
+		u := discordGuildsURL + "?limit=" + strconv.Itoa(discordGuildsPage)
+		if after != "" {
+			u += "&after=" + url.QueryEscape(after)
+		}
+		if _, err := getProfile(client, u, &guilds); err != nil {
+			return false, err
+		}
+		for _, g := range guilds {
+			if g.ID == guild {
+				return true, nil
+			}
+		}
+		if len(guilds) < discordGuildsPage {
+			return false, nil
+		}
+		after = guilds[len(guilds)-1].ID
+	}
+}
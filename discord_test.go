@@ -0,0 +1,51 @@
+package dmv
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiscordGuildRestriction(t *testing.T) {
+	fakeProvider(t, map[string]interface{}{
+		"/token":     `{"access_token":"token","refresh_token":"refresh","expires_in":604800,"scope":"identify guilds"}`,
+		"/users/@me": `{"id":"80351110224678912","username":"gopher","verified":true,"locale":"en-US"}`,
+		"/users/@me/guilds": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// A full first page, so g2 is only found on the second.
+			switch r.FormValue("after") {
+			case "":
+				ids := make([]string, discordGuildsPage)
+				for i := range ids {
+					ids[i] = fmt.Sprintf(`{"id":"p%d"}`, i)
+				}
+				fmt.Fprint(w, "["+strings.Join(ids, ",")+"]")
+			case fmt.Sprintf("p%d", discordGuildsPage-1):
+				fmt.Fprint(w, `[{"id":"g1"},{"id":"g2"}]`)
+			default:
+				fmt.Fprint(w, `[]`)
+			}
+		}),
+	}, map[*string]string{&discordTokenURL: "/token", &discordProfileURL: "/users/@me", &discordGuildsURL: "/users/@me/guilds"})
+
+	var d *Discord
+	serveCallback("discord", AuthDiscord(&DiscordOptions{OAuth2Options: testOAuth2Options("discord"), GuildID: "g2"}), func(dd *Discord) { d = dd })
+	if len(d.Errors) > 0 {
+		t.Fatal(d.Errors)
+	}
+	if d.Profile.ID != "80351110224678912" || !d.Profile.Verified {
+		t.Errorf("Profile not mapped, got %+v", d.Profile)
+	}
+	if claims := d.Identity().Claims; claims["locale"] != "en-US" {
+		t.Errorf("Raw profile not kept, got %s", d.RawProfile)
+	}
+	if d.Token == nil || d.Token.Expiry.IsZero() || !reflect.DeepEqual(d.Token.Scopes(), []string{"identify", "guilds"}) {
+		t.Errorf("Token metadata not kept, got %+v", d.Token)
+	}
+
+	serveCallback("discord", AuthDiscord(&DiscordOptions{OAuth2Options: testOAuth2Options("discord"), GuildID: "g3"}), func(dd *Discord) { d = dd })
+	if len(d.Errors) == 0 {
+		t.Error("User should not be a member of g3")
+	}
+}
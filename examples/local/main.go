@@ -6,39 +6,74 @@
 package main
 
 import (
-	"code.google.com/p/go.crypto/bcrypt"
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/render"
-	"github.com/martini-contrib/sessions"
 	"github.com/tomsteele/dmv"
+	"github.com/tomsteele/dmv/password"
+	"github.com/tomsteele/dmv/session"
 	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
+	"net/http"
 )
 
 // User of the application.
 type User struct {
-	ID           bson.ObjectId `bson:"_id"`
-	Email        string        `bson:"email"`
-	PasswordHash string        `bson:"password_hash"`
+	ID    bson.ObjectId `bson:"_id"`
+	Email string        `bson:"email"`
+	Hash  string        `bson:"password_hash"`
+}
+
+// PasswordHash implements dmv.User.
+func (u *User) PasswordHash() string {
+	return u.Hash
+}
+
+// userStore implements dmv.UserStore using MongoDB.
+type userStore struct {
+	session *mgo.Session
+}
+
+func (s *userStore) FindByLogin(login string) (dmv.User, error) {
+	db := s.session.Copy()
+	defer db.Close()
+	u := &User{}
+	if err := db.DB("dmv").C("users").Find(bson.M{"email": login}).One(u); err == mgo.ErrNotFound {
+		return nil, dmv.ErrUserNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// UpdatePasswordHash implements dmv.HashUpdater so hashes are upgraded when
+// the password policy changes.
+func (s *userStore) UpdatePasswordHash(u dmv.User, hash string) error {
+	db := s.session.Copy()
+	defer db.Close()
+	return db.DB("dmv").C("users").UpdateId(u.(*User).ID, bson.M{"$set": bson.M{"password_hash": hash}})
 }
 
 func main() {
 	m := martini.Classic()
-	store := sessions.NewCookieStore([]byte("secret123"))
-	m.Use(sessions.Sessions("my_session", store))
+	sessions, err := session.New(&session.Options{
+		// Use a random key kept secret from config in a real application.
+		Keys:     [][]byte{[]byte("0123456789abcdef0123456789abcdef")},
+		LoginURL: "/login",
+	})
+	if err != nil {
+		panic(err)
+	}
 	m.Use(render.Renderer())
-	m.Use(DB())
+	mongo := DB()
+	m.Use(Mongo(mongo))
+	users := &userStore{session: mongo}
 
-	m.Get("/", func(s sessions.Session, r render.Render, db *mgo.Database) {
-		if s.Get("userID") == nil {
-			r.Redirect("/login", 302)
-			return
-		}
+	m.Get("/", sessions.RequireLogin(), func(s *session.Session, w http.ResponseWriter, r render.Render, db *mgo.Database) {
 		// Attempt to find the user by the ID provided by the session.
 		u := &User{}
-		if err := db.C("users").Find(bson.M{"_id": bson.ObjectIdHex(s.Get("userID").(string))}).One(&u); err != nil {
+		if err := db.C("users").Find(bson.M{"_id": bson.ObjectIdHex(s.Identity)}).One(&u); err != nil {
 			// User wasn't found.
-			s.Clear()
+			sessions.Logout(w)
 			r.Redirect("/login", 302)
 			return
 		}
@@ -49,35 +84,26 @@ func main() {
 		r.HTML(200, "login", nil)
 	})
 
-	m.Post("/login", dmv.AuthLocal(&dmv.LocalOptions{}), func(s sessions.Session, l *dmv.Local, r render.Render, db *mgo.Database) {
-		// There were errors in the request.
+	m.Post("/login", dmv.AuthLocal(&dmv.LocalOptions{Store: users}), func(w http.ResponseWriter, l *dmv.Local, r render.Render) {
+		// There were errors in the request, the user wasn't found or
+		// the password was wrong.
 		if len(l.Errors) > 0 {
 			r.HTML(200, "login", "Invalid username or password!")
 			return
 		}
-		// Attempt to find the user by l.Username.
-		u := &User{}
-		if err := db.C("users").Find(bson.M{"email": l.Username}).One(&u); err != nil {
-			// User wasn't found.
-			r.HTML(200, "login", "Invalid username or password!")
+		// Password was correct. Start a new session and redirect.
+		if _, err := sessions.Login(w, l.User.(*User).ID.Hex()); err != nil {
+			r.HTML(500, "login", "Could not start session")
 			return
 		}
-		// Compare the password.
-		if err := bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(l.Password)); err != nil {
-			// Password was wrong.
-			r.HTML(200, "login", "Invalid username or password!")
-			return
-		}
-		// Password was correct. Set the session variable and redirect.
-		s.Set("userID", u.ID.Hex())
 		r.Redirect("/", 302)
 	})
 
 	m.Run()
 }
 
-// DB maps a MongoDB session to a request.
-func DB() martini.Handler {
+// DB connects to MongoDB and creates the test user.
+func DB() *mgo.Session {
 	session, err := mgo.Dial("mongodb://localhost")
 	if err != nil {
 		panic(err)
@@ -87,12 +113,19 @@ func DB() martini.Handler {
 	if _, err := session.DB("dmv").C("users").RemoveAll(bson.M{}); err != nil {
 		panic(err)
 	}
-	hash, _ := bcrypt.GenerateFromPassword([]byte("Password1"), 10)
-	u := &User{ID: bson.NewObjectId(), Email: "gopher@gophermail.com", PasswordHash: string(hash)}
+	hash, err := password.Hash("Password1")
+	if err != nil {
+		panic(err)
+	}
+	u := &User{ID: bson.NewObjectId(), Email: "gopher@gophermail.com", Hash: hash}
 	if err := session.DB("dmv").C("users").Insert(u); err != nil {
 		panic(err)
 	}
+	return session
+}
 
+// Mongo maps a MongoDB session to a request.
+func Mongo(session *mgo.Session) martini.Handler {
 	return func(c martini.Context) {
 		s := session.Clone()
 		c.Map(s.DB("dmv"))
@@ -1,10 +1,7 @@
 package dmv
 
 import (
-	"encoding/json"
-	"io/ioutil"
 	"net/http"
-	"net/url"
 
 	"github.com/go-martini/martini"
 )
@@ -16,10 +13,8 @@ var (
 // Facebook stores the access and refresh tokens along with the users
 // profile.
 type Facebook struct {
-	Errors       []error
-	AccessToken  string
-	RefreshToken string
-	Profile      FacebookProfile
+	OAuth2Result
+	Profile FacebookProfile
 }
 
 // FacebookProfile stores information about the user from facebook.
@@ -35,6 +30,24 @@ type FacebookProfile struct {
 	Email      string `json:"email"`
 }
 
+// Identity returns the user as an Identity. Facebook does not report
+// whether the email address is verified.
+func (fb *Facebook) Identity() *Identity {
+	id := &Identity{
+		Errors:   fb.Errors,
+		ReturnTo: fb.ReturnTo,
+		Provider: "facebook",
+		Subject:  fb.Profile.ID,
+		Email:    fb.Profile.Email,
+		Name:     fb.Profile.Name,
+		Claims:   profileClaims(fb.RawProfile, fb.Profile),
+	}
+	if fb.Profile.ID != "" {
+		id.AvatarURL = "https://graph.facebook.com/" + fb.Profile.ID + "/picture"
+	}
+	return id
+}
+
 // AuthFacebook authenticates users using Facebook and OAuth2.0. After
 // handling a callback request, a request is made to get the users
 // facebook profile and a Facebook struct will be mapped to the
@@ -80,46 +93,24 @@ type FacebookProfile struct {
 //         })
 //     }
 func AuthFacebook(opts *OAuth2Options) martini.Handler {
-	opts.AuthURL = "https://www.facebook.com/dialog/oauth"
-	opts.TokenURL = "https://graph.facebook.com/oauth/access_token"
+	return newFacebook(opts).handler()
+}
 
-	return func(r *http.Request, w http.ResponseWriter, c martini.Context) {
-		transport := makeTransport(opts, r)
-		cbPath := ""
-		if u, err := url.Parse(transport.Config.RedirectURL); err == nil {
-			cbPath = u.Path
-		}
-		if r.URL.Path != cbPath {
-			http.Redirect(w, r, transport.Config.AuthCodeURL(""), http.StatusFound)
-			return
-		}
+// NewAuthFacebook is like AuthFacebook, but returns an error if opts are not
+// valid.
+func NewAuthFacebook(opts *OAuth2Options) (martini.Handler, error) {
+	return newFacebook(opts).validHandler()
+}
+
+func newFacebook(opts *OAuth2Options) *oauth2Handler {
+	o := *opts
+	o.AuthURL = "https://www.facebook.com/dialog/oauth"
+	o.TokenURL = "https://graph.facebook.com/oauth/access_token"
+	return newOAuth2Handler("facebook", &o, o.Validate(), func(*http.Request) (oauth2User, profileFunc) {
 		fb := &Facebook{}
-		defer c.Map(fb)
-		code := r.FormValue("code")
-		tk, err := transport.Exchange(code)
-		if err != nil {
-			fb.Errors = append(fb.Errors, err)
-			return
-		}
-		fb.AccessToken = tk.AccessToken
-		fb.RefreshToken = tk.RefreshToken
-		resp, err := transport.Client().Get(fbProfileURL)
-		if err != nil {
-			fb.Errors = append(fb.Errors, err)
-			return
+		return fb, func(client *http.Client) (err error) {
+			fb.RawProfile, err = getProfile(client, fbProfileURL, &fb.Profile)
+			return err
 		}
-		defer resp.Body.Close()
-		profile := &FacebookProfile{}
-		data, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			fb.Errors = append(fb.Errors, err)
-			return
-		}
-		if err := json.Unmarshal(data, profile); err != nil {
-			fb.Errors = append(fb.Errors, err)
-			return
-		}
-		fb.Profile = *profile
-		return
-	}
+	})
 }
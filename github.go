@@ -1,10 +1,7 @@
 package dmv
 
 import (
-	"encoding/json"
-	"io/ioutil"
 	"net/http"
-	"net/url"
 
 	"github.com/go-martini/martini"
 )
@@ -15,10 +12,8 @@ var (
 
 // Github stores the access and refresh tokens along with the users profile.
 type Github struct {
-	Errors       []error
-	AccessToken  string
-	RefreshToken string
-	Profile      GithubProfile
+	OAuth2Result
+	Profile GithubProfile
 }
 
 // GithubProfile stores information about the user from Github.
@@ -28,6 +23,27 @@ type GithubProfile struct {
 	Login   string `json:"login"`
 	HTMLURL string `json:"html_url"`
 	Email   string `json:"email"`
+	// AvatarURL is the URL of the users profile picture.
+	AvatarURL string `json:"avatar_url"`
+}
+
+// Identity returns the user as an Identity. Github does not report whether
+// the public email address is verified.
+func (gh *Github) Identity() *Identity {
+	name := gh.Profile.Name
+	if name == "" {
+		name = gh.Profile.Login
+	}
+	return &Identity{
+		Errors:    gh.Errors,
+		ReturnTo:  gh.ReturnTo,
+		Provider:  "github",
+		Subject:   intSubject(gh.Profile.ID),
+		Email:     gh.Profile.Email,
+		Name:      name,
+		AvatarURL: gh.Profile.AvatarURL,
+		Claims:    profileClaims(gh.RawProfile, gh.Profile),
+	}
 }
 
 // AuthGithub authenticates users using Github and OAuth2.0. After handling
@@ -74,46 +90,24 @@ type GithubProfile struct {
 //         })
 //     }
 func AuthGithub(opts *OAuth2Options) martini.Handler {
-	opts.AuthURL = "https://github.com/login/oauth/authorize"
-	opts.TokenURL = "https://github.com/login/oauth/access_token"
+	return newGithub(opts).handler()
+}
 
-	return func(r *http.Request, w http.ResponseWriter, c martini.Context) {
-		transport := makeTransport(opts, r)
-		cbPath := ""
-		if u, err := url.Parse(transport.Config.RedirectURL); err == nil {
-			cbPath = u.Path
-		}
-		if r.URL.Path != cbPath {
-			http.Redirect(w, r, transport.Config.AuthCodeURL(""), http.StatusFound)
-			return
-		}
+// NewAuthGithub is like AuthGithub, but returns an error if opts are not
+// valid.
+func NewAuthGithub(opts *OAuth2Options) (martini.Handler, error) {
+	return newGithub(opts).validHandler()
+}
+
+func newGithub(opts *OAuth2Options) *oauth2Handler {
+	o := *opts
+	o.AuthURL = "https://github.com/login/oauth/authorize"
+	o.TokenURL = "https://github.com/login/oauth/access_token"
+	return newOAuth2Handler("github", &o, o.Validate(), func(*http.Request) (oauth2User, profileFunc) {
 		gh := &Github{}
-		defer c.Map(gh)
-		code := r.FormValue("code")
-		tk, err := transport.Exchange(code)
-		if err != nil {
-			gh.Errors = append(gh.Errors, err)
-			return
-		}
-		gh.AccessToken = tk.AccessToken
-		gh.RefreshToken = tk.RefreshToken
-		resp, err := transport.Client().Get(ghProfileURL)
-		if err != nil {
-			gh.Errors = append(gh.Errors, err)
-			return
+		return gh, func(client *http.Client) (err error) {
+			gh.RawProfile, err = getProfile(client, ghProfileURL, &gh.Profile)
+			return err
 		}
-		defer resp.Body.Close()
-		profile := &GithubProfile{}
-		data, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			gh.Errors = append(gh.Errors, err)
-			return
-		}
-		if err := json.Unmarshal(data, profile); err != nil {
-			gh.Errors = append(gh.Errors, err)
-			return
-		}
-		gh.Profile = *profile
-		return
-	}
+	})
 }
@@ -0,0 +1,133 @@
+package dmv
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-martini/martini"
+)
+
+var (
+	gitlabBaseURL = "https://gitlab.com"
+)
+
+// GitlabOptions contains options for authenticating with GitLab.
+type GitlabOptions struct {
+	OAuth2Options
+	// The URL of the GitLab instance, for example https://gitlab.example.com.
+	// Defaults to https://gitlab.com.
+	BaseURL string
+	// If not empty, only members of this group are allowed to log in. The
+	// value is the full path of the group, for example "org/team". Checking
+	// membership requires the "read_api" scope.
+	Group string
+}
+
+// Gitlab stores the access and refresh tokens along with the users profile.
+type Gitlab struct {
+	OAuth2Result
+	Profile GitlabProfile
+}
+
+// GitlabProfile stores information about the user from GitLab.
+type GitlabProfile struct {
+	ID        int    `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	Email     string `json:"email"`
+	AvatarURL string `json:"avatar_url"`
+	State     string `json:"state"`
+}
+
+// Identity returns the user as an Identity.
+func (gl *Gitlab) Identity() *Identity {
+	return &Identity{
+		Errors:    gl.Errors,
+		ReturnTo:  gl.ReturnTo,
+		Provider:  "gitlab",
+		Subject:   intSubject(gl.Profile.ID),
+		Email:     gl.Profile.Email,
+		Name:      gl.Profile.Name,
+		AvatarURL: gl.Profile.AvatarURL,
+		Claims:    profileClaims(gl.RawProfile, gl.Profile),
+	}
+}
+
+// AuthGitlab authenticates users using GitLab and OAuth2.0. Self-managed
+// instances are supported by setting BaseURL. After handling a callback
+// request, a request is made to get the users GitLab profile and a Gitlab
+// struct will be mapped to the current request context. The "read_user" or
+// "openid" scope is required to read the profile.
+//
+// This function should be called twice in each application, once on the login
+// handler and once on the callback handler.
+//
+//     glOpts := &dmv.GitlabOptions{
+//         OAuth2Options: dmv.OAuth2Options{
+//             ClientID:     "oauth_id",
+//             ClientSecret: "oauth_secret",
+//             RedirectURL:  "http://host:port/auth/callback/gitlab",
+//             Scopes:       []string{"read_user", "read_api"},
+//         },
+//         BaseURL: "https://gitlab.example.com",
+//         Group:   "engineering",
+//     }
+//
+//     m.Get("/auth/gitlab", dmv.AuthGitlab(glOpts))
+//     m.Get("/auth/callback/gitlab", dmv.AuthGitlab(glOpts), func(gl *dmv.Gitlab, req *http.Request, w http.ResponseWriter) {
+//         // Handle any errors.
+//         if len(gl.Errors) > 0 {
+//             http.Error(w, "OAuth failure", http.StatusInternalServerError)
+//             return
+//         }
+//         // Do something in a database to create or find the user by the GitLab profile id.
+//         user := findOrCreateByGitlabID(gl.Profile.ID)
+//         s.Set("userID", user.ID)
+//         http.Redirect(w, req, "/", http.StatusFound)
+//     })
+func AuthGitlab(opts *GitlabOptions) martini.Handler {
+	return newGitlab(opts).handler()
+}
+
+// NewAuthGitlab is like AuthGitlab, but returns an error if opts are not
+// valid.
+func NewAuthGitlab(opts *GitlabOptions) (martini.Handler, error) {
+	return newGitlab(opts).validHandler()
+}
+
+func newGitlab(opts *GitlabOptions) *oauth2Handler {
+	o := *opts
+	if o.BaseURL == "" {
+		o.BaseURL = gitlabBaseURL
+	}
+	o.BaseURL = strings.TrimRight(o.BaseURL, "/")
+	o.AuthURL = o.BaseURL + "/oauth/authorize"
+	o.TokenURL = o.BaseURL + "/oauth/token"
+	return newOAuth2Handler("gitlab", &o.OAuth2Options, o.Validate(), func(*http.Request) (oauth2User, profileFunc) {
+		gl := &Gitlab{}
+		return gl, func(client *http.Client) error {
+			profile := GitlabProfile{}
+			raw, err := getProfile(client, o.BaseURL+"/api/v4/user", &profile)
+			gl.RawProfile = raw
+			if err != nil {
+				return err
+			}
+			if o.Group != "" {
+				memberURL := fmt.Sprintf("%s/api/v4/groups/%s/members/all/%d", o.BaseURL, url.PathEscape(o.Group), profile.ID)
+				resp, err := client.Get(memberURL)
+				if err != nil {
+					return err
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					return errors.New("user is not a member of the gitlab group")
+				}
+			}
+			gl.Profile = profile
+			return nil
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package dmv
+
+import "testing"
+
+func TestGitlabGroupMembership(t *testing.T) {
+	ts := fakeProvider(t, map[string]interface{}{
+		"/oauth/token": `{"access_token":"token","refresh_token":"refresh"}`,
+		"/api/v4/user": `{"id":42,"username":"gopher","state":"active"}`,
+		"/api/v4/groups/org%2Fteam/members/all/42": `{"id":42}`,
+	}, nil)
+	glOpts := func(group string) *GitlabOptions {
+		return &GitlabOptions{OAuth2Options: testOAuth2Options("gitlab"), BaseURL: ts.URL + "/", Group: group}
+	}
+
+	var gl *Gitlab
+	serveCallback("gitlab", AuthGitlab(glOpts("org/team")), func(g *Gitlab) { gl = g })
+	if len(gl.Errors) > 0 {
+		t.Fatal(gl.Errors)
+	}
+	if gl.Profile.ID != 42 || gl.Profile.Username != "gopher" || gl.RefreshToken != "refresh" {
+		t.Errorf("Profile not mapped, got %+v", gl)
+	}
+
+	serveCallback("gitlab", AuthGitlab(glOpts("other")), func(g *Gitlab) { gl = g })
+	if len(gl.Errors) == 0 {
+		t.Error("User should not be a member of other")
+	}
+}
@@ -1,10 +1,7 @@
 package dmv
 
 import (
-	"encoding/json"
-	"io/ioutil"
 	"net/http"
-	"net/url"
 
 	"github.com/go-martini/martini"
 )
@@ -15,10 +12,8 @@ var (
 
 // Google stores the access and refresh tokens along with the user profile.
 type Google struct {
-	Errors       []error
-	AccessToken  string
-	RefreshToken string
-	Profile      GoogleProfile
+	OAuth2Result
+	Profile GoogleProfile
 }
 
 // GoogleProfile stores information from the users google+ profile.
@@ -28,6 +23,24 @@ type GoogleProfile struct {
 	FamilyName  string `json:"family_name"`
 	GivenName   string `json:"given_name"`
 	Email       string `json:"email"`
+	// VerifiedEmail is true if Google has verified Email.
+	VerifiedEmail bool   `json:"verified_email"`
+	Picture       string `json:"picture"`
+}
+
+// Identity returns the user as an Identity.
+func (goog *Google) Identity() *Identity {
+	return &Identity{
+		Errors:        goog.Errors,
+		ReturnTo:      goog.ReturnTo,
+		Provider:      "google",
+		Subject:       goog.Profile.ID,
+		Email:         goog.Profile.Email,
+		EmailVerified: goog.Profile.VerifiedEmail,
+		Name:          goog.Profile.DisplayName,
+		AvatarURL:     goog.Profile.Picture,
+		Claims:        profileClaims(goog.RawProfile, goog.Profile),
+	}
 }
 
 // AuthGoogle authenticates users using Google and OAuth2.0. After handling
@@ -75,46 +88,26 @@ type GoogleProfile struct {
 //         })
 //     }
 func AuthGoogle(opts *OAuth2Options) martini.Handler {
-	opts.AuthURL = "https://accounts.google.com/o/oauth2/auth"
-	opts.TokenURL = "https://accounts.google.com/o/oauth2/token"
+	return newGoogle(opts).handler()
+}
 
-	return func(r *http.Request, w http.ResponseWriter, c martini.Context) {
-		transport := makeTransport(opts, r)
-		cbPath := ""
-		if u, err := url.Parse(transport.Config.RedirectURL); err == nil {
-			cbPath = u.Path
-		}
-		if r.URL.Path != cbPath {
-			http.Redirect(w, r, transport.Config.AuthCodeURL(""), http.StatusFound)
-			return
-		}
+// NewAuthGoogle is like AuthGoogle, but returns an error if opts are not
+// valid.
+func NewAuthGoogle(opts *OAuth2Options) (martini.Handler, error) {
+	return newGoogle(opts).validHandler()
+}
+
+func newGoogle(opts *OAuth2Options) *oauth2Handler {
+	o := *opts
+	o.AuthURL = "https://accounts.google.com/o/oauth2/auth"
+	o.TokenURL = "https://accounts.google.com/o/oauth2/token"
+	h := newOAuth2Handler("google", &o, o.Validate(), func(*http.Request) (oauth2User, profileFunc) {
 		goog := &Google{}
-		defer c.Map(goog)
-		code := r.FormValue("code")
-		tk, err := transport.Exchange(code)
-		if err != nil {
-			goog.Errors = append(goog.Errors, err)
-			return
-		}
-		goog.AccessToken = tk.AccessToken
-		goog.RefreshToken = tk.RefreshToken
-		resp, err := transport.Client().Get(googleProfileURL)
-		if err != nil {
-			goog.Errors = append(goog.Errors, err)
-			return
+		return goog, func(client *http.Client) (err error) {
+			goog.RawProfile, err = getProfile(client, googleProfileURL, &goog.Profile)
+			return err
 		}
-		defer resp.Body.Close()
-		profile := &GoogleProfile{}
-		data, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			goog.Errors = append(goog.Errors, err)
-			return
-		}
-		if err := json.Unmarshal(data, profile); err != nil {
-			goog.Errors = append(goog.Errors, err)
-			return
-		}
-		goog.Profile = *profile
-		return
-	}
+	})
+	h.includeGrantedScopes = true
+	return h
 }
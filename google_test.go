@@ -27,11 +27,58 @@ func TestLoginRedirect(t *testing.T) {
 	if recorder.Code != 302 {
 		t.Errorf("Not being redirected to the auth page.")
 	}
-	if location != "https://accounts.google.com/o/oauth2/auth?access_type=&approval_prompt=&client_id=client_id&redirect_uri=refresh_url&response_type=code&scope=x+y&state=" {
+	u, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("state") == "" {
+		t.Errorf("State missing from %v", location)
+	}
+	q.Del("state")
+	u.RawQuery = q.Encode()
+	if u.String() != "https://accounts.google.com/o/oauth2/auth?client_id=client_id&redirect_uri=refresh_url&response_type=code&scope=x+y" {
 		t.Errorf("Not being redirected to the right page, %v found", location)
 	}
 }
 
+func TestLoginRedirectAuthParams(t *testing.T) {
+	googleOpts := &OAuth2Options{
+		ClientID:     "client_id",
+		ClientSecret: "client_secret",
+		RedirectURL:  "refresh_url",
+		AuthParams:   map[string]string{"prompt": "select_account", "access_type": "offline"},
+		AuthParamsFunc: func(req *http.Request) map[string]string {
+			return map[string]string{"login_hint": req.FormValue("email"), "prompt": "", "state": "fixed"}
+		},
+	}
+	m := testMartini()
+	m.Get("/auth/google", AuthGoogle(googleOpts))
+
+	recorder := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/google?email=gopher@example.com", nil)
+	m.ServeHTTP(recorder, r)
+	u, err := url.Parse(recorder.HeaderMap.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if q.Get("login_hint") != "gopher@example.com" || q.Get("access_type") != "offline" {
+		t.Errorf("AuthParams not sent, got %v", u)
+	}
+	if _, ok := q["prompt"]; ok {
+		t.Errorf("AuthParamsFunc should remove prompt, got %v", u)
+	}
+	if q.Get("state") == "fixed" {
+		t.Errorf("AuthParamsFunc should not replace state, got %v", u)
+	}
+
+	googleOpts.AuthParams = map[string]string{"redirect_uri": "http://evil.com"}
+	if err := googleOpts.Validate(); err == nil {
+		t.Error("expected Validate to reject a reserved AuthParams key")
+	}
+}
+
 func TestLoginRedirectFunc(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	googleOpts := &OAuth2Options{
@@ -65,5 +112,5 @@ func testMartini() *martini.ClassicMartini {
 	m := martini.New()
 	m.MapTo(r, (*martini.Routes)(nil))
 	m.Action(r.Handle)
-	return &martini.ClassicMartini{m, r}
+	return &martini.ClassicMartini{Martini: m, Router: r}
 }
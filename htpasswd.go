@@ -0,0 +1,178 @@
+package dmv
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Htpasswd is a Verifier backed by an Apache style htpasswd file. Entries
+// may be hashed with bcrypt ($2y$), SHA1 ({SHA}) or APR1-MD5 ($apr1$). The
+// file is reloaded when its modification time or size changes.
+type Htpasswd struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	users   map[string]string
+}
+
+// NewHtpasswd loads the htpasswd file at path.
+//
+//    htpasswd, err := dmv.NewHtpasswd("/etc/myapp/.htpasswd")
+//    if err != nil {
+//        log.Fatal(err)
+//    }
+//    m.Get("/protected", dmv.AuthBasicWithVerifier(htpasswd), func(b *dmv.Basic) {
+//        // b.Username has been verified.
+//    })
+func NewHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{path: path}
+	if err := h.reload(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Verify reports whether password matches the entry for username. If the
+// file has changed since it was last read it is reloaded first, if reloading
+// fails the previously loaded entries are used.
+func (h *Htpasswd) Verify(username, password string) bool {
+	h.mu.Lock()
+	h.reload()
+	hash, ok := h.users[username]
+	h.mu.Unlock()
+	if !ok {
+		// Compare against a dummy hash so unknown users take as long
+		// as known ones.
+		compareHtpasswd(dummyHash, password)
+		return false
+	}
+	return compareHtpasswd(hash, password)
+}
+
+// reload reads the file if it has changed. h.mu must be held, or h not yet
+// shared.
+func (h *Htpasswd) reload() error {
+	fi, err := os.Stat(h.path)
+	if err != nil {
+		return err
+	}
+	if h.users != nil && fi.ModTime().Equal(h.modTime) && fi.Size() == h.size {
+		return nil
+	}
+	f, err := os.Open(h.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	users := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return errors.New("malformed htpasswd entry for " + parts[0])
+		}
+		users[parts[0]] = parts[1]
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	h.users = users
+	h.modTime = fi.ModTime()
+	h.size = fi.Size()
+	return nil
+}
+
+// compareHtpasswd compares password to an htpasswd hash in constant time.
+func compareHtpasswd(hash, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2y$"), strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed := "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(computed)) == 1
+	case strings.HasPrefix(hash, "$apr1$"):
+		parts := strings.SplitN(hash[len("$apr1$"):], "$", 2)
+		if len(parts) != 2 {
+			return false
+		}
+		computed := apr1(password, parts[0])
+		return subtle.ConstantTimeCompare([]byte(hash), []byte(computed)) == 1
+	}
+	return false
+}
+
+// apr1 computes Apache's variant of the MD5 crypt algorithm.
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+	alt := md5.Sum([]byte(password + salt + password))
+	ctx := md5.New()
+	ctx.Write([]byte(password + magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(alt[:])
+		} else {
+			ctx.Write(alt[:i])
+		}
+	}
+	for i := len(pw); i != 0; i >>= 1 {
+		if i&1 != 0 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	final := ctx.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		c := md5.New()
+		if i&1 != 0 {
+			c.Write(pw)
+		} else {
+			c.Write(final)
+		}
+		if i%3 != 0 {
+			c.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			c.Write(pw)
+		}
+		if i&1 != 0 {
+			c.Write(final)
+		} else {
+			c.Write(pw)
+		}
+		final = c.Sum(nil)
+	}
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	out := make([]byte, 0, 22)
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out = append(out, itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, idx := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(final[idx[0]])<<16|uint32(final[idx[1]])<<8|uint32(final[idx[2]]), 4)
+	}
+	to64(uint32(final[11]), 2)
+	return magic + salt + "$" + string(out)
+}
@@ -0,0 +1,85 @@
+package dmv
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHtpasswd(t *testing.T) {
+	dir, err := ioutil.TempDir("", "htpasswd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".htpasswd")
+	hash, _ := bcrypt.GenerateFromPassword([]byte("bcryptpass"), bcrypt.MinCost)
+	data := "# comment\n" +
+		"bcrypt:" + string(hash) + "\n" +
+		"sha:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n" +
+		"apr:$apr1$r31.....$ARC3pREO82RIm0aQ2zszC0\n"
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewHtpasswd(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		username, password string
+		ok                 bool
+	}{
+		{"bcrypt", "bcryptpass", true},
+		{"bcrypt", "password", false},
+		{"sha", "password", true},
+		{"sha", "Password", false},
+		{"apr", "password", true},
+		{"apr", "passwore", false},
+		{"nobody", "password", false},
+	}
+	for _, tt := range tests {
+		if ok := h.Verify(tt.username, tt.password); ok != tt.ok {
+			t.Errorf("Verify(%q, %q) = %v, want %v", tt.username, tt.password, ok, tt.ok)
+		}
+	}
+
+	// Changes to the file are picked up.
+	if err := ioutil.WriteFile(path, []byte("sha:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(path, later, later)
+	if h.Verify("apr", "password") {
+		t.Error("Removed user should not verify after reload")
+	}
+	if !h.Verify("sha", "password") {
+		t.Error("Existing user should verify after reload")
+	}
+}
+
+func Test_BasicAuthWithVerifier(t *testing.T) {
+	v := VerifierFunc(func(username, password string) bool {
+		return username == "gopher" && password == "golf"
+	})
+	m := martini.Classic()
+	m.Get("/protected", AuthBasicWithVerifier(v), func(b *Basic) string {
+		return "hi " + b.Username
+	})
+	for creds, code := range map[string]int{"gopher:golf": 200, "gopher:gulf": 401} {
+		res := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/protected", nil)
+		r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(creds)))
+		m.ServeHTTP(res, r)
+		if res.Code != code {
+			t.Errorf("%s: got %d, want %d", creds, res.Code, code)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package dmv
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/go-martini/martini"
+)
+
+// Identity is a provider independent view of an authenticated user. Every
+// Auth* handler maps an *Identity to the request context alongside its
+// provider specific struct, so one handler can serve all login routes.
+//
+//    findOrCreate := func(id *dmv.Identity, w http.ResponseWriter) {
+//        if len(id.Errors) > 0 {
+//            http.Error(w, "Login failed", http.StatusUnauthorized)
+//            return
+//        }
+//        // Find or create the user by id.Provider and id.Subject.
+//    }
+//    m.Get("/auth/callback/github", dmv.AuthGithub(ghOpts), findOrCreate)
+//    m.Get("/auth/callback/google", dmv.AuthGoogle(googOpts), findOrCreate)
+type Identity struct {
+	// Errors are the errors of the provider specific struct. The other
+	// fields may be incomplete if there are any.
+	Errors []error
+	// Provider is the name of the provider, such as "github" or "local".
+	Provider string
+	// Subject is the users unique and stable ID at the provider. It is
+	// only unique together with Provider.
+	Subject string
+	Email   string
+	// EmailVerified is true only if the provider reports that the user has
+	// confirmed Email.
+	EmailVerified bool
+	Name          string
+	AvatarURL     string
+	// Claims is the provider profile as a map, or nil for local providers.
+	Claims map[string]interface{}
+	// ReturnTo is the validated return_to parameter of the login request
+	// for OAuth providers.
+	ReturnTo string
+}
+
+// ErrNotVerified is in the Identity of Local, Basic and Digest users whose
+// credentials were not checked by a UserStore, Verifier or HA1, so the
+// username may be anything the client sent.
+var ErrNotVerified = errors.New("credentials not verified")
+
+// identifier is implemented by the provider specific structs.
+type identifier interface {
+	Identity() *Identity
+}
+
+// errorAdder is implemented by provider specific structs with Errors.
+type errorAdder interface {
+	addError(err error)
+}
+
+// mapWithIdentity maps v and its Identity to c. It is deferred by handlers
+// so the Identity includes any errors added before returning. An Identity
+// without errors must have a Subject, otherwise an error is added to both.
+func mapWithIdentity(c martini.Context, v identifier) {
+	id := v.Identity()
+	if len(id.Errors) == 0 && id.Subject == "" {
+		err := errors.New(id.Provider + " did not return a user ID")
+		if e, ok := v.(errorAdder); ok {
+			e.addError(err)
+		}
+		id.Errors = append(id.Errors, err)
+	}
+	c.Map(v)
+	c.Map(id)
+}
+
+// intSubject formats a numeric user ID as a Subject. Zero is not a valid
+// ID, so it is returned as "".
+func intSubject(id int) string {
+	if id == 0 {
+		return ""
+	}
+	return strconv.Itoa(id)
+}
+
+// profileClaims returns the raw profile JSON as a map, or profile, a struct
+// with json tags, if there is no raw JSON.
+func profileClaims(raw json.RawMessage, profile interface{}) map[string]interface{} {
+	data := []byte(raw)
+	if len(data) == 0 {
+		var err error
+		if data, err = json.Marshal(profile); err != nil {
+			return nil
+		}
+	}
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil
+	}
+	return claims
+}
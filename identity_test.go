@@ -0,0 +1,122 @@
+package dmv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/go-martini/martini"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func Test_ProviderIdentity(t *testing.T) {
+	for _, tt := range []struct {
+		v    identifier
+		want Identity
+	}{
+		{
+			&Github{Profile: GithubProfile{ID: 42, Login: "gopher", Email: "g@example.com", AvatarURL: "https://a/g.png"}},
+			Identity{Provider: "github", Subject: "42", Email: "g@example.com", Name: "gopher", AvatarURL: "https://a/g.png"},
+		},
+		{
+			&Google{Profile: GoogleProfile{ID: "108", DisplayName: "Gopher", Email: "g@example.com", VerifiedEmail: true}},
+			Identity{Provider: "google", Subject: "108", Email: "g@example.com", EmailVerified: true, Name: "Gopher"},
+		},
+		{
+			&Discord{Profile: DiscordProfile{ID: "80", Username: "gopher", Avatar: "abc", Email: "g@example.com", Verified: true}},
+			Identity{Provider: "discord", Subject: "80", Email: "g@example.com", EmailVerified: true, Name: "gopher", AvatarURL: "https://cdn.discordapp.com/avatars/80/abc.png"},
+		},
+		{
+			&Microsoft{Profile: MicrosoftProfile{ID: "ms", DisplayName: "Gopher", Mail: "g@example.com"}},
+			Identity{Provider: "microsoft", Subject: "ms", Email: "g@example.com", Name: "Gopher"},
+		},
+		{
+			&Apple{Profile: AppleProfile{Sub: "001", Email: "g@example.com", EmailVerified: true}},
+			Identity{Provider: "apple", Subject: "001", Email: "g@example.com", EmailVerified: true},
+		},
+	} {
+		got := tt.v.Identity()
+		if got.Claims == nil {
+			t.Errorf("%s: expected claims", tt.want.Provider)
+		}
+		got.Claims = nil
+		if !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("got %+v, want %+v", *got, tt.want)
+		}
+	}
+	id := (&Github{Profile: GithubProfile{ID: 42, Login: "gopher"}}).Identity()
+	if id.Claims["login"] != "gopher" {
+		t.Errorf("expected login claim, got %v", id.Claims)
+	}
+}
+
+func Test_IdentityMapped(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("golf"), bcrypt.MinCost)
+	store := NewMemoryUserStore()
+	store.Add("gopher", &LocalUser{ID: "1", Login: "gopher", Hash: string(hash)})
+	m := martini.Classic()
+	handler := func(id *Identity) string {
+		if len(id.Errors) > 0 {
+			return id.Provider + " error: " + id.Errors[0].Error()
+		}
+		return id.Provider + ":" + id.Subject
+	}
+	m.Post("/login", AuthLocal(&LocalOptions{}), handler)
+	m.Post("/login/store", AuthLocal(&LocalOptions{Store: store}), handler)
+	m.Get("/basic", AuthBasic(), handler)
+	m.Get("/basic/verified", AuthBasicWithVerifier(VerifierFunc(func(username, password string) bool {
+		return username == "gopher" && password == "golf"
+	})), handler)
+	login := func(path string) func() *http.Request {
+		return func() *http.Request {
+			data := url.Values{"username": {"gopher"}, "password": {"golf"}}
+			req, _ := http.NewRequest("POST", path, bytes.NewBufferString(data.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return req
+		}
+	}
+	basic := func(path string) func() *http.Request {
+		return func() *http.Request {
+			req, _ := http.NewRequest("GET", path, nil)
+			req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("gopher:golf")))
+			return req
+		}
+	}
+	for _, tt := range []struct {
+		req  func() *http.Request
+		body string
+	}{
+		{login("/login"), "local error: " + ErrNotVerified.Error()},
+		{login("/login/store"), "local:gopher"},
+		{func() *http.Request {
+			req, _ := http.NewRequest("POST", "/login", nil)
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			return req
+		}, "local error: missing form body"},
+		{basic("/basic"), "basic error: " + ErrNotVerified.Error()},
+		{basic("/basic/verified"), "basic:gopher"},
+	} {
+		res := httptest.NewRecorder()
+		m.ServeHTTP(res, tt.req())
+		if res.Body.String() != tt.body {
+			t.Errorf("got %q, want %q", res.Body.String(), tt.body)
+		}
+	}
+}
+
+func Test_IdentityRequiresSubject(t *testing.T) {
+	gh := &Github{}
+	m := martini.New()
+	m.Use(func(c martini.Context) {
+		mapWithIdentity(c, gh)
+	})
+	m.Use(func(id *Identity) {
+		if len(id.Errors) != 1 || len(gh.Errors) != 1 {
+			t.Errorf("expected an error for an empty subject, got %v and %v", id.Errors, gh.Errors)
+		}
+	})
+	m.ServeHTTP(httptest.NewRecorder(), &http.Request{URL: &url.URL{}})
+}
@@ -0,0 +1,130 @@
+package dmv
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/tomsteele/dmv/oauth"
+)
+
+// jwks fetches and caches the RSA signing keys published by an OpenID
+// Connect provider. Keys are fetched again when an unknown key ID is seen,
+// which handles key rotation.
+type jwks struct {
+	url    string
+	client *http.Client
+	mu     sync.Mutex
+	keys   map[string]*rsa.PublicKey
+}
+
+// newJWKS returns a jwks for url, fetched with client or, if it is nil, a
+// client with the default timeout.
+func newJWKS(url string, client *http.Client) *jwks {
+	if client == nil {
+		client = &http.Client{Timeout: oauth.DefaultTimeout}
+	}
+	return &jwks{url: url, client: client, keys: make(map[string]*rsa.PublicKey)}
+}
+
+// key returns the public key with the ID kid.
+func (j *jwks) key(kid string) (*rsa.PublicKey, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if k, ok := j.keys[kid]; ok {
+		return k, nil
+	}
+	if err := j.fetch(); err != nil {
+		return nil, err
+	}
+	if k, ok := j.keys[kid]; ok {
+		return k, nil
+	}
+	return nil, errors.New("id_token signed with unknown key")
+}
+
+func (j *jwks) fetch() error {
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New("unexpected HTTP status fetching keys " + resp.Status)
+	}
+	set := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return err
+	}
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return err
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	j.keys = keys
+	return nil
+}
+
+// verify checks the RS256 signature of idToken against the key set and
+// decodes its claims into v. The caller is responsible for validating the
+// claims themselves.
+func (j *jwks) verify(idToken string, v interface{}) error {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed id_token")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[0], "="))
+	if err != nil {
+		return err
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return err
+	}
+	if header.Alg != "RS256" {
+		return errors.New("unsupported id_token algorithm " + header.Alg)
+	}
+	key, err := j.key(header.Kid)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[2], "="))
+	if err != nil {
+		return err
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig); err != nil {
+		return errors.New("invalid id_token signature")
+	}
+	return decodeIDToken(idToken, v)
+}
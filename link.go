@@ -0,0 +1,241 @@
+package dmv
+
+import (
+	"errors"
+	"strings"
+	"sync"
+)
+
+var (
+	// ErrNotLinked is returned when an identity is not linked to a user.
+	ErrNotLinked = errors.New("identity is not linked to a user")
+	// ErrAlreadyLinked is returned by Linker.Link when the identity is
+	// linked to a different user.
+	ErrAlreadyLinked = errors.New("identity is linked to another user")
+	// ErrLastLoginMethod is returned by Linker.Unlink when removing the
+	// link would leave the user with no way to log in.
+	ErrLastLoginMethod = errors.New("cannot remove the last login method")
+)
+
+// Link connects an identity at a provider to a user of the application.
+type Link struct {
+	UserID        string
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// LinkStore stores the links between identities and users.
+type LinkStore interface {
+	// FindLink returns the link for provider and subject, or ErrNotLinked.
+	FindLink(provider, subject string) (*Link, error)
+	// FindVerifiedEmail returns a link whose email is verified and equal
+	// to email ignoring case, or ErrNotLinked.
+	FindVerifiedEmail(email string) (*Link, error)
+	// Links returns all links for the user.
+	Links(userID string) ([]*Link, error)
+	// AddLink adds or replaces the link for l.Provider and l.Subject.
+	AddLink(l *Link) error
+	// RemoveLink removes the link for provider and subject.
+	RemoveLink(provider, subject string) error
+}
+
+// LinkOptions are used to pass conditional arguments to NewLinker.
+type LinkOptions struct {
+	Store LinkStore
+	// If true, an identity with a verified email that is not yet linked is
+	// linked to the user of another identity with the same verified email.
+	// Only enable this if every provider used verifies email addresses
+	// correctly, as it allows whoever controls the address to log in.
+	MergeVerifiedEmail bool
+	// HasPassword reports whether the user can also log in with a
+	// password. If it is nil only links are counted as login methods.
+	HasPassword func(userID string) (bool, error)
+}
+
+// Linker links the identities from one or more providers to the users of
+// an application.
+//
+//    linker := dmv.NewLinker(&dmv.LinkOptions{Store: store})
+//    callback := func(id *dmv.Identity, w http.ResponseWriter, req *http.Request) {
+//        if s, err := sessions.Get(req); err == nil {
+//            // Already logged in, add this provider to the account.
+//            if err := linker.Link(s.Identity, id); err != nil {
+//                // Handle dmv.ErrAlreadyLinked.
+//            }
+//            return
+//        }
+//        userID, err := linker.Resolve(id)
+//        if err == dmv.ErrNotLinked {
+//            userID = createUser(id)
+//            err = linker.Link(userID, id)
+//        }
+//        // Handle err and start a session for userID.
+//    }
+type Linker struct {
+	opts LinkOptions
+}
+
+// NewLinker returns a Linker using opts.
+func NewLinker(opts *LinkOptions) *Linker {
+	return &Linker{opts: *opts}
+}
+
+// Resolve returns the ID of the user linked to id. If there is none and
+// MergeVerifiedEmail is set, id is linked to the user with the same
+// verified email. Otherwise ErrNotLinked is returned and the application
+// should create a user and call Link.
+func (l *Linker) Resolve(id *Identity) (string, error) {
+	if len(id.Errors) > 0 {
+		return "", id.Errors[0]
+	}
+	link, err := l.opts.Store.FindLink(id.Provider, id.Subject)
+	if err == nil {
+		return link.UserID, nil
+	}
+	if err != ErrNotLinked {
+		return "", err
+	}
+	if !l.opts.MergeVerifiedEmail || !id.EmailVerified || id.Email == "" {
+		return "", ErrNotLinked
+	}
+	existing, err := l.opts.Store.FindVerifiedEmail(id.Email)
+	if err != nil {
+		return "", err
+	}
+	if err := l.opts.Store.AddLink(newLink(existing.UserID, id)); err != nil {
+		return "", err
+	}
+	return existing.UserID, nil
+}
+
+// Link links id to the user, for example when a logged in user adds
+// another provider. ErrAlreadyLinked is returned if id is linked to a
+// different user.
+func (l *Linker) Link(userID string, id *Identity) error {
+	if len(id.Errors) > 0 {
+		return id.Errors[0]
+	}
+	link, err := l.opts.Store.FindLink(id.Provider, id.Subject)
+	if err == nil && link.UserID != userID {
+		return ErrAlreadyLinked
+	}
+	if err != nil && err != ErrNotLinked {
+		return err
+	}
+	return l.opts.Store.AddLink(newLink(userID, id))
+}
+
+// Unlink removes the link for provider and subject from the user. It
+// returns ErrNotLinked if the link belongs to someone else, and
+// ErrLastLoginMethod if the user would have no links left and no password.
+func (l *Linker) Unlink(userID, provider, subject string) error {
+	links, err := l.opts.Store.Links(userID)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, link := range links {
+		if link.Provider == provider && link.Subject == subject {
+			found = true
+		}
+	}
+	if !found {
+		return ErrNotLinked
+	}
+	if len(links) == 1 {
+		hasPassword := false
+		if l.opts.HasPassword != nil {
+			if hasPassword, err = l.opts.HasPassword(userID); err != nil {
+				return err
+			}
+		}
+		if !hasPassword {
+			return ErrLastLoginMethod
+		}
+	}
+	return l.opts.Store.RemoveLink(provider, subject)
+}
+
+func newLink(userID string, id *Identity) *Link {
+	return &Link{
+		UserID:        userID,
+		Provider:      id.Provider,
+		Subject:       id.Subject,
+		Email:         id.Email,
+		EmailVerified: id.EmailVerified,
+	}
+}
+
+// MemoryLinkStore is a LinkStore that keeps links in memory. It is safe for
+// concurrent use and mostly useful for tests and small applications.
+type MemoryLinkStore struct {
+	mu    sync.RWMutex
+	links map[string]*Link
+}
+
+// NewMemoryLinkStore returns an empty MemoryLinkStore.
+func NewMemoryLinkStore() *MemoryLinkStore {
+	return &MemoryLinkStore{links: make(map[string]*Link)}
+}
+
+func linkKey(provider, subject string) string {
+	return provider + "\x00" + subject
+}
+
+// FindLink implements LinkStore.
+func (s *MemoryLinkStore) FindLink(provider, subject string) (*Link, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	link, ok := s.links[linkKey(provider, subject)]
+	if !ok {
+		return nil, ErrNotLinked
+	}
+	copied := *link
+	return &copied, nil
+}
+
+// FindVerifiedEmail implements LinkStore.
+func (s *MemoryLinkStore) FindVerifiedEmail(email string) (*Link, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, link := range s.links {
+		if link.EmailVerified && strings.EqualFold(link.Email, email) {
+			copied := *link
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotLinked
+}
+
+// Links implements LinkStore.
+func (s *MemoryLinkStore) Links(userID string) ([]*Link, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var links []*Link
+	for _, link := range s.links {
+		if link.UserID == userID {
+			copied := *link
+			links = append(links, &copied)
+		}
+	}
+	return links, nil
+}
+
+// AddLink implements LinkStore.
+func (s *MemoryLinkStore) AddLink(l *Link) error {
+	copied := *l
+	s.mu.Lock()
+	s.links[linkKey(l.Provider, l.Subject)] = &copied
+	s.mu.Unlock()
+	return nil
+}
+
+// RemoveLink implements LinkStore.
+func (s *MemoryLinkStore) RemoveLink(provider, subject string) error {
+	s.mu.Lock()
+	delete(s.links, linkKey(provider, subject))
+	s.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,87 @@
+package dmv
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_LinkerResolveAndLink(t *testing.T) {
+	linker := NewLinker(&LinkOptions{Store: NewMemoryLinkStore()})
+	gh := &Identity{Provider: "github", Subject: "42", Email: "g@example.com", EmailVerified: true}
+	if _, err := linker.Resolve(gh); err != ErrNotLinked {
+		t.Fatalf("expected ErrNotLinked, got %v", err)
+	}
+	if err := linker.Link("u1", gh); err != nil {
+		t.Fatal(err)
+	}
+	if userID, err := linker.Resolve(gh); err != nil || userID != "u1" {
+		t.Errorf("expected u1, got %q, %v", userID, err)
+	}
+	if err := linker.Link("u1", gh); err != nil {
+		t.Errorf("expected relinking to the same user to succeed, got %v", err)
+	}
+	if err := linker.Link("u2", gh); err != ErrAlreadyLinked {
+		t.Errorf("expected ErrAlreadyLinked, got %v", err)
+	}
+	failed := &Identity{Provider: "google", Errors: []error{errors.New("oauth failure")}}
+	if err := linker.Link("u1", failed); err == nil {
+		t.Error("expected identity with errors to be rejected")
+	}
+
+	// Without MergeVerifiedEmail a matching email is not enough.
+	goog := &Identity{Provider: "google", Subject: "108", Email: "G@example.com", EmailVerified: true}
+	if _, err := linker.Resolve(goog); err != ErrNotLinked {
+		t.Errorf("expected ErrNotLinked without merging, got %v", err)
+	}
+}
+
+func Test_LinkerMergeVerifiedEmail(t *testing.T) {
+	store := NewMemoryLinkStore()
+	linker := NewLinker(&LinkOptions{Store: store, MergeVerifiedEmail: true})
+	linker.Link("u1", &Identity{Provider: "github", Subject: "42", Email: "g@example.com", EmailVerified: true})
+	linker.Link("u2", &Identity{Provider: "microsoft", Subject: "ms", Email: "m@example.com"})
+
+	for _, tt := range []struct {
+		id   *Identity
+		user string
+		err  error
+	}{
+		{&Identity{Provider: "google", Subject: "108", Email: "G@example.com", EmailVerified: true}, "u1", nil},
+		{&Identity{Provider: "facebook", Subject: "fb", Email: "g@example.com"}, "", ErrNotLinked},
+		{&Identity{Provider: "google", Subject: "109", Email: "m@example.com", EmailVerified: true}, "", ErrNotLinked},
+	} {
+		userID, err := linker.Resolve(tt.id)
+		if userID != tt.user || err != tt.err {
+			t.Errorf("%s/%s: got %q, %v, want %q, %v", tt.id.Provider, tt.id.Subject, userID, err, tt.user, tt.err)
+		}
+	}
+	if link, err := store.FindLink("google", "108"); err != nil || link.UserID != "u1" {
+		t.Errorf("expected merged identity to be linked, got %+v, %v", link, err)
+	}
+}
+
+func Test_LinkerUnlink(t *testing.T) {
+	hasPassword := map[string]bool{"u2": true}
+	linker := NewLinker(&LinkOptions{
+		Store: NewMemoryLinkStore(),
+		HasPassword: func(userID string) (bool, error) {
+			return hasPassword[userID], nil
+		},
+	})
+	linker.Link("u1", &Identity{Provider: "github", Subject: "42"})
+	linker.Link("u1", &Identity{Provider: "google", Subject: "108"})
+	linker.Link("u2", &Identity{Provider: "gitlab", Subject: "7"})
+
+	if err := linker.Unlink("u2", "github", "42"); err != ErrNotLinked {
+		t.Errorf("expected ErrNotLinked for another users link, got %v", err)
+	}
+	if err := linker.Unlink("u1", "github", "42"); err != nil {
+		t.Errorf("expected unlink to succeed, got %v", err)
+	}
+	if err := linker.Unlink("u1", "google", "108"); err != ErrLastLoginMethod {
+		t.Errorf("expected ErrLastLoginMethod, got %v", err)
+	}
+	if err := linker.Unlink("u2", "gitlab", "7"); err != nil {
+		t.Errorf("expected unlink to succeed for user with a password, got %v", err)
+	}
+}
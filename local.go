@@ -2,9 +2,14 @@
 package dmv
 
 import (
+	"encoding/json"
 	"errors"
 	"github.com/go-martini/martini"
+	"github.com/tomsteele/dmv/password"
+	"mime"
 	"net/http"
+	"strings"
+	"sync"
 )
 
 // Local is mapped to the martini.Context from the martini.Handler
@@ -13,25 +18,83 @@ type Local struct {
 	Errors   []error
 	Username string
 	Password string
+	// Remember is true if the RememberField was set to a true value, such
+	// as "on", "true" or "1".
+	Remember bool
+	// User is the verified user when LocalOptions.Store is set.
+	User User
+}
+
+// Identity returns the user as an Identity with the username as Subject.
+// Unless LocalOptions.Store accepted the password its Errors include
+// ErrNotVerified.
+func (l *Local) Identity() *Identity {
+	id := &Identity{Errors: l.Errors, Provider: "local", Subject: l.Username}
+	if len(l.Errors) == 0 && l.User == nil {
+		id.Errors = []error{ErrNotVerified}
+	}
+	return id
+}
+
+func (l *Local) addError(err error) {
+	l.Errors = append(l.Errors, err)
 }
 
 // LocalOptions are used to pass conditional arguments to AuthLocal.
 type LocalOptions struct {
-	// The form field to represent a username.
+	// The form field to represent a username. For JSON bodies this may be
+	// a dot separated path to a nested field, such as "user.email".
 	UsernameField string
-	// The form field to represent a password.
+	// The form field to represent a password. For JSON bodies this may be
+	// a dot separated path to a nested field.
 	PasswordField string
+	// The optional form field for a "remember me" checkbox. For JSON bodies
+	// this may be a dot separated path to a nested field.
+	RememberField string
+	// The maximum size of the request body in bytes. Defaults to 1MB.
+	MaxBodySize int64
+	// If set, the user is looked up in Store and the password compared to
+	// its hash. On success Local.User is set, otherwise
+	// ErrInvalidCredentials is added to Local.Errors. If Store implements
+	// HashUpdater, hashes that do not match PasswordPolicy are replaced
+	// after a successful login.
+	Store UserStore
+	// The policy used to verify and upgrade password hashes. Defaults to
+	// password.DefaultPolicy. Existing argon2id, scrypt and bcrypt hashes
+	// are accepted regardless of the policy.
+	PasswordPolicy *password.Policy
+	// If set, logins are refused with a *LockedError and a Retry-After
+	// header is set while the username or client IP is throttled. Every
+	// allowed attempt counts as a failure until it succeeds. When Store is
+	// set this is recorded automatically, otherwise call Throttle.Succeed
+	// with Throttle.ClientIP after checking the password.
+	Throttle *Throttle
+	// If set, the token from the CSRF field or header is checked before the
+	// credentials and ErrInvalidCSRFToken is added to Local.Errors if it
+	// does not match. The token is rotated after a successful login with
+	// Store.
+	CSRF *CSRF
 }
 
-// AuthLocal attempts to get a username and password from a request.
+// AuthLocal attempts to get a username and password from a request. Form,
+// multipart and JSON (application/json) request bodies are supported.
 //
-//     m.Post("/login", dmv.AuthLocal(), func(l *dmv.Local) {
+//     m.Post("/login", dmv.AuthLocal(&dmv.LocalOptions{}), func(l *dmv.Local) {
 //         if len(l.Errors) > 0 {
 //             // Return "invalid username or password" message  or perhaps 401.
 //         }
 //         // Lookup user by l.Username
 //         // Compare password of found user to l.Password
 //     })
+//
+// If a UserStore is set the lookup and comparison are done by AuthLocal.
+//
+//     m.Post("/login", dmv.AuthLocal(&dmv.LocalOptions{Store: store}), func(l *dmv.Local) {
+//         if len(l.Errors) > 0 {
+//             // Return "invalid username or password" message  or perhaps 401.
+//         }
+//         user := l.User.(*MyUser)
+//     })
 func AuthLocal(opts *LocalOptions) martini.Handler {
 	if opts.UsernameField == "" {
 		opts.UsernameField = "username"
@@ -39,16 +102,165 @@ func AuthLocal(opts *LocalOptions) martini.Handler {
 	if opts.PasswordField == "" {
 		opts.PasswordField = "password"
 	}
-	return func(req *http.Request, c martini.Context) {
+	if opts.MaxBodySize == 0 {
+		opts.MaxBodySize = 1 << 20
+	}
+	if opts.PasswordPolicy == nil {
+		opts.PasswordPolicy = password.DefaultPolicy
+	}
+	v := &localVerifier{policy: opts.PasswordPolicy}
+	return func(req *http.Request, w http.ResponseWriter, c martini.Context) {
 		l := &Local{}
-		l.Username = req.FormValue(opts.UsernameField)
+		defer mapWithIdentity(c, l)
+		field, err := localFields(req, opts)
+		if err != nil {
+			l.Errors = append(l.Errors, err)
+			return
+		}
+		if opts.CSRF != nil {
+			if err := opts.CSRF.Verify(req, field(opts.CSRF.opts.FieldName)); err != nil {
+				l.Errors = append(l.Errors, err)
+				return
+			}
+		}
+		l.Username = field(opts.UsernameField)
 		if l.Username == "" {
 			l.Errors = append(l.Errors, errors.New("username field not found or empty"))
 		}
-		l.Password = req.FormValue(opts.PasswordField)
+		l.Password = field(opts.PasswordField)
 		if l.Password == "" {
 			l.Errors = append(l.Errors, errors.New("password field not found or empty"))
 		}
-		c.Map(l)
+		if opts.RememberField != "" {
+			switch strings.ToLower(field(opts.RememberField)) {
+			case "on", "true", "1", "yes":
+				l.Remember = true
+			}
+		}
+		if len(l.Errors) > 0 {
+			return
+		}
+		var ip string
+		if opts.Throttle != nil {
+			ip = opts.Throttle.ClientIP(req)
+			wait, err := opts.Throttle.Check(l.Username, ip)
+			if err != nil {
+				l.Errors = append(l.Errors, err)
+				return
+			}
+			if wait > 0 {
+				setRetryAfter(w, wait)
+				l.Errors = append(l.Errors, &LockedError{RetryAfter: wait})
+				return
+			}
+		}
+		if opts.Store != nil {
+			user, err := v.verify(opts.Store, l.Username, l.Password)
+			if opts.Throttle != nil {
+				if err == nil {
+					opts.Throttle.Succeed(l.Username, ip)
+				} else if err != ErrInvalidCredentials {
+					opts.Throttle.cancel(l.Username, ip)
+				}
+			}
+			if err != nil {
+				l.Errors = append(l.Errors, err)
+				return
+			}
+			l.User = user
+			if opts.CSRF != nil {
+				opts.CSRF.Rotate(w, req)
+			}
+		}
+	}
+}
+
+// localVerifier compares passwords to the hashes of users from a UserStore.
+type localVerifier struct {
+	policy *password.Policy
+	once   sync.Once
+	dummy  string
+}
+
+// verify finds the user by login and compares password to its hash.
+// ErrInvalidCredentials is returned for both unknown users and wrong
+// passwords. Outdated hashes are replaced if store is a HashUpdater, a
+// failure to do so does not fail the login.
+func (v *localVerifier) verify(store UserStore, login, pw string) (User, error) {
+	user, err := store.FindByLogin(login)
+	if err == ErrUserNotFound {
+		// Compare against a dummy hash made with the policy so unknown
+		// users take as long to reject as known ones.
+		v.once.Do(func() {
+			v.dummy, _ = v.policy.Hash(dummyPassword)
+		})
+		v.policy.Verify(v.dummy, pw)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	ok, rehash, err := v.policy.Verify(user.PasswordHash(), pw)
+	if err != nil || !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if updater, isUpdater := store.(HashUpdater); rehash && isUpdater {
+		if hash, err := v.policy.Hash(pw); err == nil {
+			updater.UpdatePasswordHash(user, hash)
+		}
+	}
+	return user, nil
+}
+
+// localFields parses the request body according to its Content-Type and
+// returns a func to look up fields by name.
+func localFields(req *http.Request, opts *LocalOptions) (func(string) string, error) {
+	content, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if req.Body != nil {
+		req.Body = http.MaxBytesReader(nil, req.Body, opts.MaxBodySize)
+	}
+	switch {
+	case content == "application/json" || strings.HasSuffix(content, "+json"):
+		if req.Body == nil {
+			return nil, errors.New("request body is empty")
+		}
+		var body interface{}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return nil, errors.New("invalid JSON body: " + err.Error())
+		}
+		return func(path string) string {
+			return jsonField(body, path)
+		}, nil
+	case content == "multipart/form-data":
+		if err := req.ParseMultipartForm(opts.MaxBodySize); err != nil {
+			return nil, err
+		}
+	default:
+		if err := req.ParseForm(); err != nil {
+			return nil, err
+		}
+	}
+	return req.FormValue, nil
+}
+
+// jsonField returns the string or boolean value at the dot separated path
+// in v, or "" if there is no such value.
+func jsonField(v interface{}, path string) string {
+	for _, key := range strings.Split(path, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = obj[key]
+	}
+	switch value := v.(type) {
+	case string:
+		return value
+	case bool:
+		if value {
+			return "true"
+		}
+		return "false"
 	}
+	return ""
 }
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/go-martini/martini"
+	"github.com/tomsteele/dmv/password"
+	"golang.org/x/crypto/bcrypt"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -38,3 +41,103 @@ func Test_AuthLocal(t *testing.T) {
 		t.Error("AuthLocal did not return the correct username and password")
 	}
 }
+
+func Test_AuthLocalJSON(t *testing.T) {
+	m := martini.Classic()
+	opts := &LocalOptions{
+		UsernameField: "user.email",
+		PasswordField: "user.password",
+		RememberField: "remember",
+		MaxBodySize:   128,
+	}
+	m.Post("/login", AuthLocal(opts), func(l *Local, w http.ResponseWriter) {
+		if len(l.Errors) > 0 {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, "%s %s %v", l.Username, l.Password, l.Remember)
+	})
+	res := httptest.NewRecorder()
+	body := `{"user":{"email":"gopher@example.com","password":"rule"},"remember":true}`
+	req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	m.ServeHTTP(res, req)
+	if res.Body.String() != "gopher@example.com rule true" {
+		t.Error("AuthLocal did not parse the JSON body, got: ", res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	body = `{"user":{"email":"gopher@example.com","password":"` + strings.Repeat("x", 128) + `"}}`
+	req, _ = http.NewRequest("POST", "/login", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	m.ServeHTTP(res, req)
+	if res.Code != 400 {
+		t.Error("AuthLocal accepted a body larger than MaxBodySize")
+	}
+}
+
+func Test_AuthLocalStore(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("rule"), bcrypt.MinCost)
+	store := NewMemoryUserStore()
+	store.Add("gophers", &LocalUser{ID: "1", Login: "gophers", Hash: string(hash)})
+	m := martini.Classic()
+	m.Post("/login", AuthLocal(&LocalOptions{Store: store}), func(l *Local, w http.ResponseWriter) {
+		if len(l.Errors) > 0 {
+			http.Error(w, l.Errors[0].Error(), http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, l.User.(*LocalUser).ID)
+	})
+	for _, tt := range []struct{ user, pass, body string }{
+		{"gophers", "rule", "1"},
+		{"gophers", "drool", ErrInvalidCredentials.Error() + "\n"},
+		{"rustaceans", "rule", ErrInvalidCredentials.Error() + "\n"},
+	} {
+		data := url.Values{"username": {tt.user}, "password": {tt.pass}}
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(data.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		m.ServeHTTP(res, req)
+		if res.Body.String() != tt.body {
+			t.Errorf("%s/%s: got %q, want %q", tt.user, tt.pass, res.Body.String(), tt.body)
+		}
+	}
+}
+
+func Test_AuthLocalRehash(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("rule"), bcrypt.MinCost)
+	store := NewMemoryUserStore()
+	store.Add("gophers", &LocalUser{ID: "1", Login: "gophers", Hash: string(hash)})
+	policy := &password.Policy{
+		Algorithm: password.Argon2id,
+		Argon2id:  password.Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 8, KeyLength: 16},
+	}
+	m := martini.Classic()
+	m.Post("/login", AuthLocal(&LocalOptions{Store: store, PasswordPolicy: policy}), func(l *Local) string {
+		return strconv.Itoa(len(l.Errors))
+	})
+	login := func(pass string) string {
+		data := url.Values{"username": {"gophers"}, "password": {pass}}
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(data.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		m.ServeHTTP(res, req)
+		return res.Body.String()
+	}
+	stored := func() string {
+		u, _ := store.FindByLogin("gophers")
+		return u.PasswordHash()
+	}
+	if body := login("drool"); body != "1" || stored() != string(hash) {
+		t.Fatalf("failed login: got %s errors, hash %q", body, stored())
+	}
+	if body := login("rule"); body != "0" {
+		t.Fatalf("expected login to succeed, got %s errors", body)
+	}
+	if !strings.HasPrefix(stored(), "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("expected hash to be upgraded to argon2id, got %q", stored())
+	}
+	if body := login("rule"); body != "0" {
+		t.Errorf("expected login with upgraded hash to succeed, got %s errors", body)
+	}
+}
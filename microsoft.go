@@ -0,0 +1,137 @@
+package dmv
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-martini/martini"
+)
+
+var (
+	msAuthURL    = "https://login.microsoftonline.com/%s/oauth2/v2.0/authorize"
+	msTokenURL   = "https://login.microsoftonline.com/%s/oauth2/v2.0/token"
+	msProfileURL = "https://graph.microsoft.com/v1.0/me"
+)
+
+// MicrosoftOptions contains options for authenticating with Microsoft.
+type MicrosoftOptions struct {
+	OAuth2Options
+	// The tenant used to build the v2.0 endpoints. It may be "common",
+	// "organizations", "consumers" or a tenant ID. Defaults to "common".
+	Tenant string
+	// If not empty, only users whose tenant ID (tid) is in this list are
+	// allowed to log in.
+	AllowedTenants []string
+}
+
+// Microsoft stores the access and refresh tokens along with the users profile.
+type Microsoft struct {
+	OAuth2Result
+	Profile MicrosoftProfile
+}
+
+// MicrosoftProfile stores information about the user from Microsoft Graph
+// and the id_token.
+type MicrosoftProfile struct {
+	ID                string `json:"id"`
+	ObjectID          string `json:"oid"`
+	TenantID          string `json:"tid"`
+	UserPrincipalName string `json:"userPrincipalName"`
+	DisplayName       string `json:"displayName"`
+	GivenName         string `json:"givenName"`
+	Surname           string `json:"surname"`
+	Mail              string `json:"mail"`
+}
+
+// Identity returns the user as an Identity. Microsoft does not verify the
+// mail attribute, so EmailVerified is always false.
+func (ms *Microsoft) Identity() *Identity {
+	return &Identity{
+		Errors:   ms.Errors,
+		ReturnTo: ms.ReturnTo,
+		Provider: "microsoft",
+		Subject:  ms.Profile.ID,
+		Email:    ms.Profile.Mail,
+		Name:     ms.Profile.DisplayName,
+		Claims:   profileClaims(ms.RawProfile, ms.Profile),
+	}
+}
+
+// AuthMicrosoft authenticates users using Microsoft Entra ID or a Microsoft
+// account and the OAuth2.0 v2.0 endpoints. After handling a callback request,
+// a request is made to Microsoft Graph to get the users profile and a
+// Microsoft struct will be mapped to the current request context. The
+// "openid" scope is required to populate the ObjectID and TenantID fields.
+//
+// This function should be called twice in each application, once on the login
+// handler and once on the callback handler.
+//
+//     msOpts := &dmv.MicrosoftOptions{
+//         OAuth2Options: dmv.OAuth2Options{
+//             ClientID:     "oauth_id",
+//             ClientSecret: "oauth_secret",
+//             RedirectURL:  "http://host:port/auth/callback/microsoft",
+//             Scopes:       []string{"openid", "profile", "email", "User.Read"},
+//         },
+//         Tenant:         "organizations",
+//         AllowedTenants: []string{"9188040d-6c67-4c5b-b112-36a304b66dad"},
+//     }
+//
+//     m.Get("/auth/microsoft", dmv.AuthMicrosoft(msOpts))
+//     m.Get("/auth/callback/microsoft", dmv.AuthMicrosoft(msOpts), func(ms *dmv.Microsoft, req *http.Request, w http.ResponseWriter) {
+//         // Handle any errors.
+//         if len(ms.Errors) > 0 {
+//             http.Error(w, "OAuth failure", http.StatusInternalServerError)
+//             return
+//         }
+//         // Do something in a database to create or find the user by the tenant and object id.
+//         user := findOrCreateByMicrosoftID(ms.Profile.TenantID, ms.Profile.ObjectID)
+//         s.Set("userID", user.ID)
+//         http.Redirect(w, req, "/", http.StatusFound)
+//     })
+func AuthMicrosoft(opts *MicrosoftOptions) martini.Handler {
+	return newMicrosoft(opts).handler()
+}
+
+// NewAuthMicrosoft is like AuthMicrosoft, but returns an error if opts are
+// not valid.
+func NewAuthMicrosoft(opts *MicrosoftOptions) (martini.Handler, error) {
+	return newMicrosoft(opts).validHandler()
+}
+
+func newMicrosoft(opts *MicrosoftOptions) *oauth2Handler {
+	o := *opts
+	if o.Tenant == "" {
+		o.Tenant = "common"
+	}
+	o.AuthURL = fmt.Sprintf(msAuthURL, o.Tenant)
+	o.TokenURL = fmt.Sprintf(msTokenURL, o.Tenant)
+	return newOAuth2Handler("microsoft", &o.OAuth2Options, o.Validate(), func(*http.Request) (oauth2User, profileFunc) {
+		ms := &Microsoft{}
+		return ms, func(client *http.Client) error {
+			profile := MicrosoftProfile{}
+			raw, err := getProfile(client, msProfileURL, &profile)
+			ms.RawProfile = raw
+			if err != nil {
+				return err
+			}
+			if idToken := ms.Token.Extra["id_token"]; idToken != "" {
+				claims := struct {
+					ObjectID string `json:"oid"`
+					TenantID string `json:"tid"`
+				}{}
+				if err := decodeIDToken(idToken, &claims); err != nil {
+					return err
+				}
+				profile.ObjectID = claims.ObjectID
+				profile.TenantID = claims.TenantID
+			}
+			if len(o.AllowedTenants) > 0 && !containsString(o.AllowedTenants, profile.TenantID) {
+				return errors.New("microsoft tenant is not allowed")
+			}
+			ms.Profile = profile
+			return nil
+		}
+	})
+}
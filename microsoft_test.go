@@ -0,0 +1,58 @@
+package dmv
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMicrosoftLoginRedirect(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	msOpts := &MicrosoftOptions{
+		OAuth2Options: testOAuth2Options("microsoft"),
+		Tenant:        "organizations",
+	}
+	m := testMartini()
+	m.Get("/auth/microsoft", AuthMicrosoft(msOpts))
+
+	r, _ := http.NewRequest("GET", "/auth/microsoft", nil)
+	m.ServeHTTP(recorder, r)
+
+	location := recorder.HeaderMap.Get("Location")
+	if recorder.Code != 302 {
+		t.Errorf("Not being redirected to the auth page.")
+	}
+	if !strings.HasPrefix(location, "https://login.microsoftonline.com/organizations/oauth2/v2.0/authorize?") {
+		t.Errorf("Not being redirected to the right page, %v found", location)
+	}
+}
+
+func TestMicrosoftAllowedTenants(t *testing.T) {
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"oid":"o1","tid":"t1"}`))
+	fakeProvider(t, map[string]interface{}{
+		"/token": `{"access_token":"token","id_token":"h.` + claims + `.s"}`,
+		"/me":    `{"id":"o1","userPrincipalName":"gopher@example.com"}`,
+	}, map[*string]string{&msTokenURL: "/token?%s", &msProfileURL: "/me"})
+
+	var ms *Microsoft
+	serveCallback("microsoft", AuthMicrosoft(&MicrosoftOptions{
+		OAuth2Options:  testOAuth2Options("microsoft"),
+		AllowedTenants: []string{"t1"},
+	}), func(m *Microsoft) { ms = m })
+	if len(ms.Errors) > 0 {
+		t.Fatal(ms.Errors)
+	}
+	if ms.Profile.TenantID != "t1" || ms.Profile.ObjectID != "o1" || ms.Profile.UserPrincipalName != "gopher@example.com" {
+		t.Errorf("Profile not mapped, got %+v", ms.Profile)
+	}
+
+	serveCallback("microsoft", AuthMicrosoft(&MicrosoftOptions{
+		OAuth2Options:  testOAuth2Options("microsoft"),
+		AllowedTenants: []string{"t2"},
+	}), func(m *Microsoft) { ms = m })
+	if len(ms.Errors) == 0 {
+		t.Error("Tenant t1 should not be allowed")
+	}
+}
@@ -36,6 +36,7 @@ package jwt
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -50,7 +51,7 @@ import (
 	"strings"
 	"time"
 
-	"code.google.com/p/goauth2/oauth"
+	"github.com/tomsteele/dmv/oauth"
 )
 
 // These are the default/standard values for this to work for Google service accounts.
@@ -60,6 +61,10 @@ const (
 	stdAssertionType = "http://oauth.net/grant_type/jwt/1.0/bearer"
 	stdGrantType     = "urn:ietf:params:oauth:grant-type:jwt-bearer"
 	stdAud           = "https://accounts.google.com/o/oauth2/token"
+
+	// ES256 is the algorithm used to sign tokens with an ECDSA P-256 key,
+	// for example the client secret for Sign in with Apple.
+	ES256 = "ES256"
 )
 
 var (
@@ -199,6 +204,7 @@ type Token struct {
 	Header   *Header   // header used to construct the JWT
 	Key      []byte    // PEM printable encoding of the private key
 	pKey     *rsa.PrivateKey
+	ecKey    *ecdsa.PrivateKey
 
 	header string
 	claim  string
@@ -308,7 +314,7 @@ func (t *Token) sign() error {
 		return err
 	}
 	ss := fmt.Sprintf("%s.%s", t.header, t.claim)
-	if t.pKey == nil {
+	if t.pKey == nil && t.ecKey == nil {
 		err := t.parsePrivateKey()
 		if err != nil {
 			return err
@@ -316,14 +322,38 @@ func (t *Token) sign() error {
 	}
 	h := sha256.New()
 	h.Write([]byte(ss))
+	if t.Header != nil && t.Header.Algorithm == ES256 {
+		return t.signECDSA(h.Sum(nil))
+	}
+	if t.pKey == nil {
+		return ErrInvalidKey
+	}
 	b, err := rsa.SignPKCS1v15(rand.Reader, t.pKey, crypto.SHA256, h.Sum(nil))
 	t.sig = base64Encode(b)
 	return err
 }
 
+// signECDSA computes an ES256 signature, which is the 32 byte big-endian r
+// and s values concatenated together.
+func (t *Token) signECDSA(hash []byte) error {
+	if t.ecKey == nil || t.ecKey.Params().BitSize != 256 {
+		return ErrInvalidKey
+	}
+	r, s, err := ecdsa.Sign(rand.Reader, t.ecKey, hash)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 64)
+	rb, sb := r.Bytes(), s.Bytes()
+	copy(b[32-len(rb):32], rb)
+	copy(b[64-len(sb):], sb)
+	t.sig = base64Encode(b)
+	return nil
+}
+
 // parsePrivateKey converts the Token's Key ([]byte) into a parsed
-// rsa.PrivateKey.  If the key is not well formed this method will return an
-// ErrInvalidKey error.
+// rsa.PrivateKey, or an ecdsa.PrivateKey for ES256 tokens.  If the key is not
+// well formed this method will return an ErrInvalidKey error.
 func (t *Token) parsePrivateKey() error {
 	block, _ := pem.Decode(t.Key)
 	if block == nil {
@@ -333,12 +363,18 @@ func (t *Token) parsePrivateKey() error {
 	if err != nil {
 		parsedKey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
 		if err != nil {
-			return err
+			parsedKey, err = x509.ParseECPrivateKey(block.Bytes)
+			if err != nil {
+				return err
+			}
 		}
 	}
-	var ok bool
-	t.pKey, ok = parsedKey.(*rsa.PrivateKey)
-	if !ok {
+	switch k := parsedKey.(type) {
+	case *rsa.PrivateKey:
+		t.pKey = k
+	case *ecdsa.PrivateKey:
+		t.ecKey = k
+	default:
 		return ErrInvalidKey
 	}
 	return nil
@@ -9,6 +9,8 @@ package jwt
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
@@ -16,7 +18,9 @@ import (
 	"encoding/json"
 	"encoding/pem"
 	"io/ioutil"
+	"math/big"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 )
@@ -305,6 +309,28 @@ func TestTokenSign(t *testing.T) {
 	}
 }
 
+// Test that ES256 tokens are signed with an ECDSA key.
+func TestTokenSignES256(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	tok := NewToken(iss, "", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	tok.Header.Algorithm = ES256
+	enc, err := tok.Encode()
+	if err != nil {
+		t.Fatalf("TestTokenSignES256:tok.Encode: %v", err)
+	}
+	parts := strings.Split(enc, ".")
+	sig, err := base64Decode(parts[2])
+	if err != nil || len(sig) != 64 {
+		t.Fatalf("TestTokenSignES256: bad signature %q", parts[2])
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(&key.PublicKey, h[:], r, s) {
+		t.Error("TestTokenSignES256: signature does not verify")
+	}
+}
+
 // Test that the token expiration function is working.
 func TestTokenExpired(t *testing.T) {
 	c := &ClaimSet{}
@@ -37,6 +37,7 @@
 package oauth
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -148,9 +149,34 @@ type Config struct {
 	// access token.
 	// If set to "force" the user will always be prompted, and the
 	// code can be exchanged for a refresh token.
+	// Google now uses "prompt" instead, which can be set in AuthParams.
 	ApprovalPrompt string
+
+	// IncludeGrantedScopes is a Google extension that gets sent as
+	// "include_granted_scopes=true" when set, so that the token also
+	// covers scopes granted by earlier authorizations.
+	IncludeGrantedScopes bool
+
+	// AuthParams are extra parameters sent in the URL from AuthCodeURL,
+	// such as "prompt", "login_hint", "max_age", "acr_values" and
+	// "ui_locales". They cannot replace response_type, client_id, state,
+	// scope or redirect_uri.
+	AuthParams map[string]string
+
+	// HTTPClient is used for requests to TokenURL, and its settings other
+	// than Transport are used by the client returned from Transport.Client.
+	// If nil, a client using http.DefaultTransport with a timeout of
+	// DefaultTimeout is used.
+	HTTPClient *http.Client
+
+	// Retry, if set, retries GET and HEAD requests made through a
+	// Transport and token refreshes after transient failures.
+	Retry *RetryPolicy
 }
 
+// DefaultTimeout is the request timeout used when Config.HTTPClient is nil.
+const DefaultTimeout = 30 * time.Second
+
 // Token contains an end-user's tokens.
 // This is the data you must store to persist authentication.
 type Token struct {
@@ -159,12 +185,22 @@ type Token struct {
 	Expiry       time.Time // If zero the token has no (known) expiry time.
 
 	// Extra optionally contains extra metadata from the server
-	// when updating a token. The only current key that may be
-	// populated is "id_token". It may be nil and will be
-	// initialized as needed.
+	// when updating a token. The keys that may be populated are
+	// "id_token" and "scope", the scope actually granted by the
+	// server. It may be nil and will be initialized as needed.
 	Extra map[string]string
 }
 
+// Scopes returns the scopes granted by the server, split on spaces or
+// commas. It returns nil if the server did not include a scope in its
+// response, which RFC 6749 section 5.1 says means the requested scope
+// was granted.
+func (t *Token) Scopes() []string {
+	return strings.FieldsFunc(t.Extra["scope"], func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+}
+
 // Expired reports whether the token has expired or is invalid.
 func (t *Token) Expired() bool {
 	if t.AccessToken == "" {
@@ -194,20 +230,35 @@ type Transport struct {
 	mu sync.Mutex
 
 	// Transport is the HTTP transport to use when making requests.
-	// It will default to http.DefaultTransport if nil.
+	// It will default to the Transport of Config.HTTPClient, or
+	// http.DefaultTransport, if nil.
 	// (It should never be an oauth.Transport.)
 	Transport http.RoundTripper
 }
 
 // Client returns an *http.Client that makes OAuth-authenticated requests.
 func (t *Transport) Client() *http.Client {
-	return &http.Client{Transport: t}
+	c := t.httpClient()
+	c.Transport = t
+	return c
+}
+
+// httpClient returns a copy of Config.HTTPClient, or of the default client.
+func (t *Transport) httpClient() *http.Client {
+	if t.Config != nil && t.Config.HTTPClient != nil {
+		c := *t.Config.HTTPClient
+		return &c
+	}
+	return &http.Client{Timeout: DefaultTimeout}
 }
 
 func (t *Transport) transport() http.RoundTripper {
 	if t.Transport != nil {
 		return t.Transport
 	}
+	if t.Config != nil && t.Config.HTTPClient != nil && t.Config.HTTPClient.Transport != nil {
+		return t.Config.HTTPClient.Transport
+	}
 	return http.DefaultTransport
 }
 
@@ -226,15 +277,33 @@ func (c *Config) AuthCodeURL(state string) string {
 		"redirect_uri":    condVal(c.RedirectURL),
 		"access_type":     condVal(c.AccessType),
 		"approval_prompt": condVal(c.ApprovalPrompt),
-	}.Encode()
+	}
+	if c.IncludeGrantedScopes {
+		q.Set("include_granted_scopes", "true")
+	}
+	for k, v := range c.AuthParams {
+		if v != "" && !ReservedAuthParam(k) {
+			q.Set(k, v)
+		}
+	}
 	if url_.RawQuery == "" {
-		url_.RawQuery = q
+		url_.RawQuery = q.Encode()
 	} else {
-		url_.RawQuery += "&" + q
+		url_.RawQuery += "&" + q.Encode()
 	}
 	return url_.String()
 }
 
+// ReservedAuthParam reports whether the parameter k of the URL from
+// AuthCodeURL is set from the Config and cannot be set in AuthParams.
+func ReservedAuthParam(k string) bool {
+	switch k {
+	case "response_type", "client_id", "state", "scope", "redirect_uri":
+		return true
+	}
+	return false
+}
+
 func condVal(v string) []string {
 	if v == "" {
 		return nil
@@ -293,6 +362,11 @@ func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
 	req.Header.Set("Authorization", "Bearer "+accessToken)
 
 	// Make the HTTP request.
+	if t.Config != nil && t.Retry != nil && (req.Method == "GET" || req.Method == "HEAD") {
+		return t.Retry.do(req.Context(), func() (*http.Response, error) {
+			return t.transport().RoundTrip(req)
+		})
+	}
 	return t.transport().RoundTrip(req)
 }
 
@@ -384,11 +458,13 @@ func (t *Transport) AuthenticateClient() error {
 // - Reddit only accepts client secret in the Authorization header
 // - Dropbox accepts either it in URL param or Auth header, but not both.
 // - Google only accepts URL param (not spec compliant?), not Auth header
+// - Apple only accepts URL param, not Auth header
 func providerAuthHeaderWorks(tokenURL string) bool {
 	if strings.HasPrefix(tokenURL, "https://accounts.google.com/") ||
 		strings.HasPrefix(tokenURL, "https://github.com/") ||
 		strings.HasPrefix(tokenURL, "https://api.instagram.com/") ||
-		strings.HasPrefix(tokenURL, "https://www.douban.com/") {
+		strings.HasPrefix(tokenURL, "https://www.douban.com/") ||
+		strings.HasPrefix(tokenURL, "https://appleid.apple.com/") {
 		// Some sites fail to implement the OAuth2 spec fully.
 		return false
 	}
@@ -407,16 +483,28 @@ func (t *Transport) updateToken(tok *Token, v url.Values) error {
 	if bustedAuth {
 		v.Set("client_secret", t.ClientSecret)
 	}
-	client := &http.Client{Transport: t.transport()}
-	req, err := http.NewRequest("POST", t.TokenURL, strings.NewReader(v.Encode()))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	if !bustedAuth {
-		req.SetBasicAuth(t.ClientId, t.ClientSecret)
+	client := t.httpClient()
+	client.Transport = t.transport()
+	send := func() (*http.Response, error) {
+		req, err := http.NewRequest("POST", t.TokenURL, strings.NewReader(v.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if !bustedAuth {
+			req.SetBasicAuth(t.ClientId, t.ClientSecret)
+		}
+		return client.Do(req)
+	}
+	var r *http.Response
+	var err error
+	// Only refreshes are retried, replaying an authorization code is not
+	// safe.
+	if t.Retry != nil && v.Get("grant_type") == "refresh_token" {
+		r, err = t.Retry.do(context.Background(), send)
+	} else {
+		r, err = send()
 	}
-	r, err := client.Do(req)
 	if err != nil {
 		return err
 	}
@@ -429,6 +517,7 @@ func (t *Transport) updateToken(tok *Token, v url.Values) error {
 		Refresh   string `json:"refresh_token"`
 		ExpiresIn int64  `json:"expires_in"` // seconds
 		Id        string `json:"id_token"`
+		Scope     string `json:"scope"`
 	}
 
 	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
@@ -448,6 +537,7 @@ func (t *Transport) updateToken(tok *Token, v url.Values) error {
 		b.Refresh = vals.Get("refresh_token")
 		b.ExpiresIn, _ = strconv.ParseInt(vals.Get("expires_in"), 10, 64)
 		b.Id = vals.Get("id_token")
+		b.Scope = vals.Get("scope")
 	default:
 		if err = json.Unmarshal(body, &b); err != nil {
 			return fmt.Errorf("got bad response from server: %q", body)
@@ -472,5 +562,12 @@ func (t *Transport) updateToken(tok *Token, v url.Values) error {
 		}
 		tok.Extra["id_token"] = b.Id
 	}
+	// Don't overwrite the granted scope on refresh if it is omitted.
+	if b.Scope != "" {
+		if tok.Extra == nil {
+			tok.Extra = make(map[string]string)
+		}
+		tok.Extra["scope"] = b.Scope
+	}
 	return nil
 }
@@ -234,3 +234,27 @@ func TestTokenExpired(t *testing.T) {
 		}
 	}
 }
+
+func TestTokenScopes(t *testing.T) {
+	tests := []struct {
+		scope string
+		want  []string
+	}{
+		{"", nil},
+		{"openid email", []string{"openid", "email"}},
+		{"repo,gist", []string{"repo", "gist"}},
+	}
+	for _, tt := range tests {
+		tok := &Token{Extra: map[string]string{"scope": tt.scope}}
+		got := tok.Scopes()
+		if len(got) != len(tt.want) {
+			t.Errorf("scope %q: Scopes = %q; want %q", tt.scope, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("scope %q: Scopes = %q; want %q", tt.scope, got, tt.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package oauth
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried. Only GET and HEAD
+// requests made through a Transport and refresh_token grants are retried,
+// the authorization code exchange never is as a code must only be used
+// once.
+//
+// Requests are retried after network errors and 429, 502, 503 and 504
+// responses, waiting a random time of up to BaseDelay doubled for each
+// attempt, or the Retry-After of the response if that is longer.
+type RetryPolicy struct {
+	// The maximum number of attempts including the first. Defaults to 3.
+	MaxAttempts int
+	// The delay before the first retry, before jitter. Defaults to 200ms.
+	BaseDelay time.Duration
+	// The longest delay between attempts, unless Retry-After asks for
+	// longer. Defaults to 2s.
+	MaxDelay time.Duration
+	// No retry is made that would wait past MaxElapsed since the first
+	// attempt started. Defaults to 10s.
+	MaxElapsed time.Duration
+}
+
+func (p *RetryPolicy) withDefaults() RetryPolicy {
+	rp := *p
+	if rp.MaxAttempts == 0 {
+		rp.MaxAttempts = 3
+	}
+	if rp.BaseDelay == 0 {
+		rp.BaseDelay = 200 * time.Millisecond
+	}
+	if rp.MaxDelay == 0 {
+		rp.MaxDelay = 2 * time.Second
+	}
+	if rp.MaxElapsed == 0 {
+		rp.MaxElapsed = 10 * time.Second
+	}
+	return rp
+}
+
+// do calls send until it succeeds, returns a response that should not be
+// retried, or the policy is exhausted. The last response or error is
+// returned.
+func (p *RetryPolicy) do(ctx context.Context, send func() (*http.Response, error)) (*http.Response, error) {
+	rp := p.withDefaults()
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		r, err := send()
+		if attempt+1 >= rp.MaxAttempts || ctx.Err() != nil || !retryable(r, err) {
+			return r, err
+		}
+		delay := rp.backoff(attempt)
+		if r != nil {
+			if after, ok := retryAfter(r.Header.Get("Retry-After")); ok && after > delay {
+				delay = after
+			}
+		}
+		if time.Since(start)+delay > rp.MaxElapsed {
+			return r, err
+		}
+		if r != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(r.Body, 1<<16))
+			r.Body.Close()
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// backoff returns a random delay of up to BaseDelay<<attempt, capped at
+// MaxDelay.
+func (rp RetryPolicy) backoff(attempt int) time.Duration {
+	max := rp.MaxDelay
+	if attempt < 32 && rp.BaseDelay<<uint(attempt) < max {
+		max = rp.BaseDelay << uint(attempt)
+	}
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+func retryable(r *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch r.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header given in seconds or as an HTTP
+// date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,112 @@
+package oauth
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// flakyServer fails the first fail requests to each path with status.
+func flakyServer(fail, status int, retryAfter string) (*httptest.Server, map[string]int) {
+	calls := make(map[string]int)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		if calls[r.URL.Path] <= fail {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"access_token":"token2","refresh_token":"refresh2"}`)
+	}))
+	return ts, calls
+}
+
+func TestRetryGet(t *testing.T) {
+	ts, calls := flakyServer(1, http.StatusBadGateway, "")
+	defer ts.Close()
+	tr := &Transport{
+		Config: &Config{Retry: &RetryPolicy{BaseDelay: time.Millisecond}},
+		Token:  &Token{AccessToken: "token"},
+	}
+	resp, err := tr.Client().Get(ts.URL + "/profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || calls["/profile"] != 2 {
+		t.Errorf("got status %d after %d calls, want 200 after 2", resp.StatusCode, calls["/profile"])
+	}
+
+	resp, err = tr.Client().Post(ts.URL+"/post", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || calls["/post"] != 1 {
+		t.Errorf("POST should not be retried, got status %d after %d calls", resp.StatusCode, calls["/post"])
+	}
+}
+
+func TestRetryRefreshNotExchange(t *testing.T) {
+	ts, calls := flakyServer(1, http.StatusServiceUnavailable, "0")
+	defer ts.Close()
+	config := &Config{TokenURL: ts.URL + "/token", Retry: &RetryPolicy{BaseDelay: time.Millisecond}}
+
+	tr := &Transport{Config: config}
+	if _, err := tr.Exchange("code"); err == nil {
+		t.Error("expected the exchange to fail without a retry")
+	}
+	if calls["/token"] != 1 {
+		t.Errorf("exchange made %d calls, want 1", calls["/token"])
+	}
+
+	calls["/token"] = 0
+	tr = &Transport{Config: config, Token: &Token{AccessToken: "token", RefreshToken: "refresh"}}
+	if err := tr.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if tr.AccessToken != "token2" || calls["/token"] != 2 {
+		t.Errorf("got token %q after %d calls, want token2 after 2", tr.AccessToken, calls["/token"])
+	}
+}
+
+func TestRetryAfterExceedsMaxElapsed(t *testing.T) {
+	ts, calls := flakyServer(1, http.StatusTooManyRequests, "60")
+	defer ts.Close()
+	tr := &Transport{
+		Config: &Config{Retry: &RetryPolicy{MaxElapsed: time.Second}},
+		Token:  &Token{AccessToken: "token"},
+	}
+	resp, err := tr.Client().Get(ts.URL + "/profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests || calls["/profile"] != 1 {
+		t.Errorf("got status %d after %d calls, want 429 after 1", resp.StatusCode, calls["/profile"])
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   time.Duration
+		ok     bool
+	}{
+		{"", 0, false},
+		{"5", 5 * time.Second, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Wed, 21 Oct 2015 07:28:00 GMT", 0, true},
+	}
+	for _, tt := range tests {
+		if got, ok := retryAfter(tt.header); got != tt.want || ok != tt.ok {
+			t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package dmv
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/tomsteele/dmv/oauth1"
+)
+
+// OAuth1Options contains options for complete OAuth 1.0a.
+type OAuth1Options struct {
+	ConsumerKey    string
+	ConsumerSecret string
+	CallbackURL    string
+	// Accepts a func to generate the callback URL based on the request.
+	// Takes precedence over CallbackURL if both are set. If it returns ""
+	// the request is rejected with 400 Bad Request.
+	CallbackFunc    func(*http.Request) string
+	RequestTokenURL string
+	AuthURL         string
+	AccessTokenURL  string
+	// Hosts other than the request host that the return_to parameter may
+	// redirect to after login.
+	ReturnToHosts []string
+	// The client used for token and profile requests, for example to set a
+	// proxy, custom CAs or a timeout. Defaults to a client with a 30 second
+	// timeout.
+	HTTPClient *http.Client
+}
+
+// Validate checks that ConsumerKey, ConsumerSecret and either CallbackURL
+// or CallbackFunc are set. NewAuthTwitter returns this error when the
+// handler is built, AuthTwitter does not check the options.
+func (opts *OAuth1Options) Validate() error {
+	if opts.ConsumerKey == "" || opts.ConsumerSecret == "" {
+		return errors.New("ConsumerKey and ConsumerSecret are required")
+	}
+	if opts.CallbackURL == "" && opts.CallbackFunc == nil {
+		return errors.New("CallbackURL or CallbackFunc is required")
+	}
+	return nil
+}
+
+// oauth1Handler holds the oauth1.Config of a provider handler, which is
+// built once when the handler is created.
+type oauth1Handler struct {
+	config       oauth1.Config
+	callbackFunc func(*http.Request) string
+}
+
+func newOAuth1Handler(opts *OAuth1Options) *oauth1Handler {
+	return &oauth1Handler{
+		config: oauth1.Config{
+			ConsumerKey:     opts.ConsumerKey,
+			ConsumerSecret:  opts.ConsumerSecret,
+			RequestTokenURL: opts.RequestTokenURL,
+			AuthURL:         opts.AuthURL,
+			AccessTokenURL:  opts.AccessTokenURL,
+			CallbackURL:     opts.CallbackURL,
+			HTTPClient:      opts.HTTPClient,
+		},
+		callbackFunc: opts.CallbackFunc,
+	}
+}
+
+// transport returns a Transport for req using a copy of the config, with
+// the callback URL resolved from CallbackFunc if it is set.
+func (h *oauth1Handler) transport(req *http.Request) *oauth1.Transport {
+	config := h.config
+	if h.callbackFunc != nil {
+		config.CallbackURL = h.callbackFunc(req)
+	}
+	return &oauth1.Transport{Config: &config}
+}
+
+// setRequestToken stores the request token and secret in a short lived
+// cookie scoped to the callback path, they are needed to exchange the
+// request token on the callback.
+func setRequestToken(w http.ResponseWriter, name string, callbackURL string, tok *oauth1.Token) {
+	u, _ := url.Parse(callbackURL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    url.QueryEscape(tok.Token) + ":" + url.QueryEscape(tok.Secret),
+		Path:     u.Path,
+		MaxAge:   600,
+		Secure:   u.Scheme == "https",
+		HttpOnly: true,
+	})
+}
+
+// requestToken returns the request token stored by setRequestToken and
+// clears the cookie.
+func requestToken(w http.ResponseWriter, r *http.Request, name string) *oauth1.Token {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return nil
+	}
+	http.SetCookie(w, &http.Cookie{Name: name, Path: r.URL.Path, MaxAge: -1})
+	parts := strings.SplitN(cookie.Value, ":", 2)
+	if len(parts) != 2 {
+		return nil
+	}
+	tok, err1 := url.QueryUnescape(parts[0])
+	secret, err2 := url.QueryUnescape(parts[1])
+	if err1 != nil || err2 != nil {
+		return nil
+	}
+	return &oauth1.Token{Token: tok, Secret: secret}
+}
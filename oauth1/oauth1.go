@@ -0,0 +1,402 @@
+// Package oauth1 supports OAuth 1.0a three-legged flows and making
+// OAuth 1.0a signed HTTP requests using HMAC-SHA1 (RFC 5849).
+//
+// Example usage:
+//
+//	var config = &oauth1.Config{
+//		ConsumerKey:     YOUR_CONSUMER_KEY,
+//		ConsumerSecret:  YOUR_CONSUMER_SECRET,
+//		RequestTokenURL: "https://api.twitter.com/oauth/request_token",
+//		AuthURL:         "https://api.twitter.com/oauth/authenticate",
+//		AccessTokenURL:  "https://api.twitter.com/oauth/access_token",
+//		CallbackURL:     "http://you.example.org/handler",
+//	}
+//
+//	// A landing page gets a request token and redirects to the provider.
+//	func landing(w http.ResponseWriter, r *http.Request) {
+//		t := &oauth1.Transport{Config: config}
+//		rt, _ := t.RequestToken()
+//		// Store rt.Secret, it is needed to exchange the request token.
+//		http.Redirect(w, r, config.AuthorizeURL(rt), http.StatusFound)
+//	}
+//
+//	// The user will be redirected back to this handler, which exchanges
+//	// the request token and verifier for an access token.
+//	func handler(w http.ResponseWriter, r *http.Request) {
+//		rt := &oauth1.Token{Token: r.FormValue("oauth_token"), Secret: storedSecret}
+//		t := &oauth1.Transport{Config: config}
+//		t.Exchange(rt, r.FormValue("oauth_verifier"))
+//		// The Transport now has a valid Token. Create an *http.Client
+//		// with which we can make signed API requests.
+//		c := t.Client()
+//		c.Get(...)
+//	}
+package oauth1
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OAuthError is the error type returned by many operations.
+type OAuthError struct {
+	prefix string
+	msg    string
+}
+
+func (oe OAuthError) Error() string {
+	return "OAuthError: " + oe.prefix + ": " + oe.msg
+}
+
+// Config is the configuration of an OAuth 1.0a consumer.
+type Config struct {
+	// ConsumerKey is the consumer key (also called the API key) used when
+	// communicating with the configured provider.
+	ConsumerKey string
+
+	// ConsumerSecret is the consumer secret used to sign requests.
+	ConsumerSecret string
+
+	// RequestTokenURL is the URL used to obtain temporary credentials.
+	RequestTokenURL string
+
+	// AuthURL is the URL the user will be directed to in order to
+	// authorize the request token.
+	AuthURL string
+
+	// AccessTokenURL is the URL used to exchange an authorized request
+	// token for an access token.
+	AccessTokenURL string
+
+	// CallbackURL is the URL to which the user will be returned after
+	// authorizing (or denying) access. If empty "oob" is sent.
+	CallbackURL string
+
+	// HTTPClient is used for requests to RequestTokenURL and
+	// AccessTokenURL, and its settings other than Transport are used by
+	// the client returned from Transport.Client. If nil, a client using
+	// http.DefaultTransport with a timeout of DefaultTimeout is used.
+	HTTPClient *http.Client
+}
+
+// DefaultTimeout is the request timeout used when Config.HTTPClient is nil.
+const DefaultTimeout = 30 * time.Second
+
+// Token contains a token and its secret. It is used for both request
+// tokens and access tokens.
+type Token struct {
+	Token  string
+	Secret string
+
+	// Extra contains any additional parameters returned by the server
+	// with the token, for example "user_id" and "screen_name".
+	Extra map[string]string
+}
+
+// Transport implements http.RoundTripper. When configured with a valid
+// Config and Token it can be used to make signed HTTP requests.
+//
+//	t := &oauth1.Transport{Config: config, Token: token}
+//	r, err := t.Client().Get("http://example.org/url/requiring/auth")
+type Transport struct {
+	*Config
+	*Token
+
+	// Transport is the HTTP transport to use when making requests.
+	// It will default to the Transport of Config.HTTPClient, or
+	// http.DefaultTransport, if nil.
+	// (It should never be an oauth1.Transport.)
+	Transport http.RoundTripper
+}
+
+// Client returns an *http.Client that makes OAuth-signed requests.
+func (t *Transport) Client() *http.Client {
+	c := t.httpClient()
+	c.Transport = t
+	return c
+}
+
+// httpClient returns a copy of Config.HTTPClient, or of the default client.
+func (t *Transport) httpClient() *http.Client {
+	if t.Config != nil && t.Config.HTTPClient != nil {
+		c := *t.Config.HTTPClient
+		return &c
+	}
+	return &http.Client{Timeout: DefaultTimeout}
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	if t.Config != nil && t.Config.HTTPClient != nil && t.Config.HTTPClient.Transport != nil {
+		return t.Config.HTTPClient.Transport
+	}
+	return http.DefaultTransport
+}
+
+// AuthorizeURL returns a URL that the end-user should be redirected to,
+// so that they may authorize the request token.
+func (c *Config) AuthorizeURL(requestToken *Token) string {
+	url_, err := url.Parse(c.AuthURL)
+	if err != nil {
+		panic("AuthURL malformed: " + err.Error())
+	}
+	q := url.Values{"oauth_token": {requestToken.Token}}.Encode()
+	if url_.RawQuery == "" {
+		url_.RawQuery = q
+	} else {
+		url_.RawQuery += "&" + q
+	}
+	return url_.String()
+}
+
+// RequestToken obtains temporary credentials from the server. The returned
+// token's Secret must be kept until the callback so it can be passed to
+// Exchange.
+func (t *Transport) RequestToken() (*Token, error) {
+	if t.Config == nil {
+		return nil, OAuthError{"RequestToken", "no Config supplied"}
+	}
+	callback := t.CallbackURL
+	if callback == "" {
+		callback = "oob"
+	}
+	tok, err := t.updateToken(t.RequestTokenURL, nil, map[string]string{"oauth_callback": callback})
+	if err != nil {
+		return nil, err
+	}
+	if tok.Extra["oauth_callback_confirmed"] != "true" {
+		return nil, OAuthError{"RequestToken", "oauth_callback_confirmed was not true"}
+	}
+	return tok, nil
+}
+
+// Exchange takes an authorized request token and the verifier returned to
+// the callback and gets an access Token from the remote server.
+func (t *Transport) Exchange(requestToken *Token, verifier string) (*Token, error) {
+	if t.Config == nil {
+		return nil, OAuthError{"Exchange", "no Config supplied"}
+	}
+	tok, err := t.updateToken(t.AccessTokenURL, requestToken, map[string]string{"oauth_verifier": verifier})
+	if err != nil {
+		return nil, err
+	}
+	t.Token = tok
+	return tok, nil
+}
+
+// RoundTrip executes a single HTTP transaction, signing it with the
+// Transport's Config and Token.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Config == nil {
+		return nil, OAuthError{"RoundTrip", "no Config supplied"}
+	}
+	if t.Token == nil {
+		return nil, OAuthError{"RoundTrip", "no Token supplied"}
+	}
+	// To set the Authorization header, we must make a copy of the Request
+	// so that we don't modify the Request we were given.
+	// This is required by the specification of http.RoundTripper.
+	req = cloneRequest(req)
+	if err := t.sign(req, t.Token, nil); err != nil {
+		return nil, err
+	}
+	return t.transport().RoundTrip(req)
+}
+
+// updateToken makes a signed POST to tokenURL and parses the form encoded
+// token from the response.
+func (t *Transport) updateToken(tokenURL string, tok *Token, params map[string]string) (*Token, error) {
+	req, err := http.NewRequest("POST", tokenURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.sign(req, tok, params); err != nil {
+		return nil, err
+	}
+	client := t.httpClient()
+	client.Transport = t.transport()
+	r, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if r.StatusCode != 200 {
+		return nil, OAuthError{"updateToken", "Unexpected HTTP status " + r.Status}
+	}
+	vals, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	newTok := &Token{
+		Token:  vals.Get("oauth_token"),
+		Secret: vals.Get("oauth_token_secret"),
+		Extra:  make(map[string]string),
+	}
+	if newTok.Token == "" {
+		return nil, OAuthError{"updateToken", "received empty token from server"}
+	}
+	for k := range vals {
+		if k != "oauth_token" && k != "oauth_token_secret" {
+			newTok.Extra[k] = vals.Get(k)
+		}
+	}
+	return newTok, nil
+}
+
+// sign adds an OAuth Authorization header to req. tok may be nil when
+// requesting temporary credentials.
+func (t *Transport) sign(req *http.Request, tok *Token, params map[string]string) error {
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     t.ConsumerKey,
+		"oauth_nonce":            nonce(),
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        strconv.FormatInt(time.Now().Unix(), 10),
+		"oauth_version":          "1.0",
+	}
+	tokenSecret := ""
+	if tok != nil {
+		oauthParams["oauth_token"] = tok.Token
+		tokenSecret = tok.Secret
+	}
+	for k, v := range params {
+		oauthParams[k] = v
+	}
+	form, err := formParams(req)
+	if err != nil {
+		return err
+	}
+	oauthParams["oauth_signature"] = signature(req.Method, req.URL, form, oauthParams, t.ConsumerSecret, tokenSecret)
+	req.Header.Set("Authorization", authorizationHeader(oauthParams))
+	return nil
+}
+
+// formParams returns the form encoded body parameters of req, which are
+// included in the signature. The body is restored so it can be sent.
+func formParams(req *http.Request) (url.Values, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	content, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if content != "application/x-www-form-urlencoded" {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return url.ParseQuery(string(body))
+}
+
+// signature computes the HMAC-SHA1 signature of a request as described in
+// RFC 5849 section 3.4.
+func signature(method string, u *url.URL, form url.Values, oauthParams map[string]string, consumerSecret, tokenSecret string) string {
+	var pairs []string
+	for k, vs := range u.Query() {
+		for _, v := range vs {
+			pairs = append(pairs, encode(k)+"="+encode(v))
+		}
+	}
+	for k, vs := range form {
+		for _, v := range vs {
+			pairs = append(pairs, encode(k)+"="+encode(v))
+		}
+	}
+	for k, v := range oauthParams {
+		if k != "oauth_signature" {
+			pairs = append(pairs, encode(k)+"="+encode(v))
+		}
+	}
+	sort.Strings(pairs)
+	base := strings.ToUpper(method) + "&" + encode(baseURL(u)) + "&" + encode(strings.Join(pairs, "&"))
+	mac := hmac.New(sha1.New, []byte(encode(consumerSecret)+"&"+encode(tokenSecret)))
+	mac.Write([]byte(base))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// baseURL returns the base string URI of u, without the query and with the
+// default port removed.
+func baseURL(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Host)
+	if (scheme == "http" && strings.HasSuffix(host, ":80")) ||
+		(scheme == "https" && strings.HasSuffix(host, ":443")) {
+		host = host[:strings.LastIndex(host, ":")]
+	}
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return scheme + "://" + host + path
+}
+
+func authorizationHeader(oauthParams map[string]string) string {
+	keys := make([]string, 0, len(oauthParams))
+	for k := range oauthParams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = encode(k) + `="` + encode(oauthParams[k]) + `"`
+	}
+	return "OAuth " + strings.Join(parts, ", ")
+}
+
+// encode percent encodes s as described in RFC 5849 section 3.6, which
+// leaves only unreserved characters unescaped.
+func encode(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+	return b.String()
+}
+
+func nonce() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// cloneRequest returns a clone of the provided *http.Request.
+// The clone is a shallow copy of the struct and its Header map.
+func cloneRequest(r *http.Request) *http.Request {
+	// shallow copy of the struct
+	r2 := new(http.Request)
+	*r2 = *r
+	// deep copy of the Header
+	r2.Header = make(http.Header)
+	for k, s := range r.Header {
+		r2.Header[k] = s
+	}
+	return r2
+}
@@ -0,0 +1,106 @@
+package oauth1
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// The example request from Twitter's "Creating a signature" documentation.
+func TestSignature(t *testing.T) {
+	u, _ := url.Parse("https://api.twitter.com/1.1/statuses/update.json?include_entities=true")
+	form := url.Values{"status": {"Hello Ladies + Gentlemen, a signed OAuth request!"}}
+	oauthParams := map[string]string{
+		"oauth_consumer_key":     "xvz1evFS4wEEPTGEFPHBog",
+		"oauth_nonce":            "kYjzVBB8Y0ZFabxSWbWovY3uYSQ2pTgmZeNu2VS4cg",
+		"oauth_signature_method": "HMAC-SHA1",
+		"oauth_timestamp":        "1318622958",
+		"oauth_token":            "370773112-GmHxMAgYyLbNEtIKZeRNFsMKPR9EyMZeS9weJAEb",
+		"oauth_version":          "1.0",
+	}
+	sig := signature("POST", u, form, oauthParams,
+		"kAcSOqF21Fu85e7zjz7ZN2U4ZRhfV3WpwPAoE3Z7kBw", "LswwdoUaIvS8ltyTt5jkRh4J50vUPVVHtR2YPi5kE")
+	if sig != "hCtSmYh+iHYCEqBWrE7C7hYmtUk=" {
+		t.Errorf("signature = %q, want %q", sig, "hCtSmYh+iHYCEqBWrE7C7hYmtUk=")
+	}
+}
+
+func TestEncode(t *testing.T) {
+	tests := map[string]string{
+		"Ladies + Gentlemen": "Ladies%20%2B%20Gentlemen",
+		"An encoded string!": "An%20encoded%20string%21",
+		"Dogs, Cats & Mice":  "Dogs%2C%20Cats%20%26%20Mice",
+		"☃":                  "%E2%98%83",
+		"-._~":               "-._~",
+	}
+	for in, want := range tests {
+		if g := encode(in); g != want {
+			t.Errorf("encode(%q) = %q, want %q", in, g, want)
+		}
+	}
+}
+
+func TestThreeLegged(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "OAuth ") || !strings.Contains(auth, `oauth_signature="`) {
+			t.Errorf("request to %s not signed: %q", r.URL.Path, auth)
+		}
+		switch r.URL.Path {
+		case "/request_token":
+			if !strings.Contains(auth, `oauth_callback="http%3A%2F%2Fexample.org%2Fcb"`) {
+				t.Errorf("oauth_callback missing: %q", auth)
+			}
+			io.WriteString(w, "oauth_token=rt&oauth_token_secret=rts&oauth_callback_confirmed=true")
+		case "/access_token":
+			if !strings.Contains(auth, `oauth_token="rt"`) || !strings.Contains(auth, `oauth_verifier="v3r1f13r"`) {
+				t.Errorf("request token or verifier missing: %q", auth)
+			}
+			io.WriteString(w, "oauth_token=at&oauth_token_secret=ats&screen_name=gopher")
+		case "/secure":
+			if !strings.Contains(auth, `oauth_token="at"`) {
+				t.Errorf("access token missing: %q", auth)
+			}
+			io.WriteString(w, "payload")
+		}
+	}
+	server := httptest.NewServer(http.HandlerFunc(handler))
+	defer server.Close()
+
+	config := &Config{
+		ConsumerKey:     "k3y",
+		ConsumerSecret:  "s3cr3t",
+		RequestTokenURL: server.URL + "/request_token",
+		AuthURL:         server.URL + "/authorize",
+		AccessTokenURL:  server.URL + "/access_token",
+		CallbackURL:     "http://example.org/cb",
+	}
+	transport := &Transport{Config: config}
+	rt, err := transport.RequestToken()
+	if err != nil {
+		t.Fatalf("RequestToken: %v", err)
+	}
+	if g, w := config.AuthorizeURL(rt), server.URL+"/authorize?oauth_token=rt"; g != w {
+		t.Errorf("AuthorizeURL = %q, want %q", g, w)
+	}
+	tok, err := transport.Exchange(&Token{Token: rt.Token, Secret: rt.Secret}, "v3r1f13r")
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if tok.Token != "at" || tok.Secret != "ats" || tok.Extra["screen_name"] != "gopher" {
+		t.Errorf("Exchange returned %+v", tok)
+	}
+	resp, err := transport.Client().Get(server.URL + "/secure")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	if string(b) != "payload" {
+		t.Errorf("body = %q", b)
+	}
+}
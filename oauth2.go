@@ -1,9 +1,16 @@
 package dmv
 
 import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
 	"net/http"
+	"net/url"
 	"strings"
 
+	"github.com/go-martini/martini"
 	"github.com/tomsteele/dmv/oauth"
 )
 
@@ -14,13 +21,80 @@ type OAuth2Options struct {
 	RedirectURL  string
 	// Accepts a func to generate the redirect URL based on the request. Useful
 	// if you want to redirect to a relative path. Takes precedence over
-	// RedirectURL if both are set.
+	// RedirectURL if both are set. If it returns "" the request is rejected
+	// with 400 Bad Request, see RedirectRelativeFuncWithOptions.
 	RedirectFunc func(*http.Request) string
 	Scopes       []string
 	AuthURL      string
 	TokenURL     string
+	// Hosts other than the request host that the return_to parameter may
+	// redirect to after login. Relative paths are always allowed.
+	ReturnToHosts []string
+	// The client used for token and profile requests, for example to set a
+	// proxy, custom CAs or a timeout. Defaults to a client with a 30 second
+	// timeout.
+	HTTPClient *http.Client
+	// If set, profile requests and token refreshes are retried after
+	// transient failures. The authorization code exchange is never retried.
+	Retry *oauth.RetryPolicy
+	// Scopes that a signed in user may be asked for in addition to Scopes,
+	// by adding a space separated list to the scope parameter of the login
+	// request, for example "/auth/github?scope=repo". Requests for other
+	// scopes are rejected with 400 Bad Request.
+	StepUpScopes []string
+	// Returns the stored token of the signed in user, or nil, on the
+	// callback of a login that requested StepUpScopes. If the callback is
+	// for the same account the new token is merged into a copy of it,
+	// keeping its refresh token if no new one is issued, and the scopes it
+	// did not have are reported in NewScopes. Otherwise ErrStepUpAccount is
+	// added to Errors. Requires CurrentSubject.
+	CurrentToken func(*http.Request) *oauth.Token
+	// Returns the Identity.Subject of the signed in user on the callback,
+	// which the profile of the new token must match.
+	CurrentSubject func(*http.Request) string
+	// Extra parameters sent with every authorization request, for example
+	// {"prompt": "select_account"}. See oauth.Config.AuthParams.
+	AuthParams map[string]string
+	// Returns extra parameters for the authorization request of the login
+	// request, which replace those in AuthParams. A parameter with an
+	// empty value is not sent.
+	//
+	//    AuthParamsFunc: func(req *http.Request) map[string]string {
+	//        return map[string]string{"login_hint": req.FormValue("email")}
+	//    },
+	AuthParamsFunc func(*http.Request) map[string]string
 }
 
+// ErrStepUpAccount is added to Errors when the account authorized on a
+// step-up callback is not the signed in user returned by
+// OAuth2Options.CurrentSubject.
+var ErrStepUpAccount = errors.New("authorized account is not the signed in user")
+
+// OAuth2Result holds the fields common to the structs mapped by the OAuth2
+// providers, such as Github and Google, which embed it.
+type OAuth2Result struct {
+	Errors       []error
+	AccessToken  string
+	RefreshToken string
+	// ReturnTo is the validated return_to parameter of the login request,
+	// or "" if there was none or it is not allowed.
+	ReturnTo string
+	// RawProfile is the profile response as returned by the provider, or
+	// the id_token payload for Apple, for reading fields not in Profile.
+	RawProfile json.RawMessage
+	// Token is the full token, including its expiry, id_token and granted
+	// scope.
+	Token *oauth.Token
+	// NewScopes are the scopes granted that the token returned by
+	// OAuth2Options.CurrentToken did not have, after a step-up
+	// authorization.
+	NewScopes []string
+}
+
+// RedirectRelativeFunc returns a RedirectFunc for path on the host of the
+// request. It trusts the Host, X-Forwarded-Proto and X-SSL-Request headers of
+// every request, which allows Host header injection to send authorization
+// codes to another domain. Use RedirectRelativeFuncWithOptions instead.
 func RedirectRelativeFunc(path string) func(*http.Request) string {
 	return func(req *http.Request) string {
 		proto := "http"
@@ -38,21 +112,488 @@ func RedirectRelativeFunc(path string) func(*http.Request) string {
 	}
 }
 
-func makeTransport(opts *OAuth2Options, req *http.Request) (transport *oauth.Transport) {
-	config := &oauth.Config{
-		ClientId:     opts.ClientID,
-		ClientSecret: opts.ClientSecret,
-		RedirectURL:  opts.RedirectURL,
-		Scope:        strings.Join(opts.Scopes, " "),
-		AuthURL:      opts.AuthURL,
-		TokenURL:     opts.TokenURL,
+// RedirectRelativeOptions are used to pass conditional arguments to
+// RedirectRelativeFuncWithOptions.
+type RedirectRelativeOptions struct {
+	// The hosts, including the port if not the default, the redirect URL
+	// may use. Required.
+	Hosts []string
+	// The IP addresses or CIDRs of reverse proxies whose Forwarded,
+	// X-Forwarded-Host, X-Forwarded-Proto and X-SSL-Request headers are
+	// trusted. The headers of other clients are ignored.
+	TrustedProxies []string
+}
+
+// RedirectRelativeFuncWithOptions returns a RedirectFunc for path on the
+// host of the request, or on the host reported by a trusted proxy. If the
+// host is not in opts.Hosts the func returns "" and the Auth* handler
+// responds with 400 Bad Request. An error is returned if opts.Hosts is empty
+// or a proxy is not a valid IP address or CIDR.
+//
+//     redirect, err := dmv.RedirectRelativeFuncWithOptions("/auth/callback/github", &dmv.RedirectRelativeOptions{
+//         Hosts:          []string{"example.com", "www.example.com"},
+//         TrustedProxies: []string{"10.0.0.0/8"},
+//     })
+func RedirectRelativeFuncWithOptions(path string, opts *RedirectRelativeOptions) (func(*http.Request) string, error) {
+	if len(opts.Hosts) == 0 {
+		return nil, errors.New("at least one host is required")
+	}
+	proxies, err := parseProxies(opts.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	hosts := append([]string(nil), opts.Hosts...)
+	return func(req *http.Request) string {
+		proto := "http"
+		if req.TLS != nil {
+			proto = "https"
+		}
+		host := req.Host
+		if ip := net.ParseIP(clientIP(req)); ip != nil && containsIP(proxies, ip) {
+			proto, host = forwardedProtoHost(req, proto, host)
+		}
+		for _, h := range hosts {
+			if strings.EqualFold(h, host) {
+				return proto + "://" + host + path
+			}
+		}
+		return ""
+	}, nil
+}
+
+// forwardedProtoHost returns the protocol and host from the Forwarded
+// header of req, or the X-Forwarded-* headers if it is not set. When a
+// header has several values the last one, added by the proxy connected to
+// the server, is used.
+func forwardedProtoHost(req *http.Request, proto, host string) (string, string) {
+	if forwarded := req.Header.Values("Forwarded"); len(forwarded) > 0 {
+		elements := strings.Split(forwarded[len(forwarded)-1], ",")
+		for _, pair := range strings.Split(elements[len(elements)-1], ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			value := strings.Trim(kv[1], `"`)
+			switch strings.ToLower(kv[0]) {
+			case "proto":
+				proto = strings.ToLower(value)
+			case "host":
+				host = value
+			}
+		}
+		return proto, host
+	}
+	if v := lastHeaderValue(req, "X-Forwarded-Host"); v != "" {
+		host = v
+	}
+	if v := lastHeaderValue(req, "X-Forwarded-Proto"); v != "" {
+		proto = strings.ToLower(v)
+	} else if req.Header.Get("X-SSL-Request") == "on" {
+		proto = "https"
+	}
+	return proto, host
+}
+
+// lastHeaderValue returns the last comma separated value of the header.
+func lastHeaderValue(req *http.Request, name string) string {
+	values := req.Header.Values(name)
+	if len(values) == 0 {
+		return ""
+	}
+	parts := strings.Split(values[len(values)-1], ",")
+	return strings.TrimSpace(parts[len(parts)-1])
+}
+
+// parseProxies parses IP addresses and CIDRs of trusted proxies.
+func parseProxies(trusted []string) ([]*net.IPNet, error) {
+	var proxies []*net.IPNet
+	for _, proxy := range trusted {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// forwardedFor returns the client addresses from the for parameters of the
+// Forwarded header of req, or the X-Forwarded-For header if it is not set,
+// in the order the proxies added them. Ports are removed.
+func forwardedFor(req *http.Request) []string {
+	var hops []string
+	if forwarded := req.Header.Values("Forwarded"); len(forwarded) > 0 {
+		for _, element := range strings.Split(strings.Join(forwarded, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					hops = append(hops, stripPort(strings.Trim(kv[1], `"`)))
+				}
+			}
+		}
+		return hops
+	}
+	for _, value := range req.Header.Values("X-Forwarded-For") {
+		for _, hop := range strings.Split(value, ",") {
+			hops = append(hops, stripPort(strings.TrimSpace(hop)))
+		}
+	}
+	return hops
+}
+
+// stripPort removes the port and IPv6 brackets from a node of a Forwarded
+// or X-Forwarded-For header.
+func stripPort(node string) string {
+	if host, _, err := net.SplitHostPort(node); err == nil {
+		return host
+	}
+	return strings.Trim(node, "[]")
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that ClientID, ClientSecret and either RedirectURL or
+// RedirectFunc are set. The NewAuth* functions return this error when the
+// handler is built, the Auth* functions do not check the options.
+func (opts *OAuth2Options) Validate() error {
+	if opts.ClientSecret == "" {
+		return errors.New("ClientSecret is required")
+	}
+	return opts.validate()
+}
+
+// validate checks the options required by every provider, including those
+// that do not use a static ClientSecret.
+func (opts *OAuth2Options) validate() error {
+	if opts.ClientID == "" {
+		return errors.New("ClientID is required")
+	}
+	if opts.RedirectURL == "" && opts.RedirectFunc == nil {
+		return errors.New("RedirectURL or RedirectFunc is required")
+	}
+	if opts.CurrentToken != nil && opts.CurrentSubject == nil {
+		return errors.New("CurrentSubject is required with CurrentToken")
+	}
+	for k := range opts.AuthParams {
+		if oauth.ReservedAuthParam(k) {
+			return errors.New("AuthParams cannot set " + k)
+		}
+	}
+	return nil
+}
+
+// oauth2User is implemented by the provider structs, which embed
+// OAuth2Result.
+type oauth2User interface {
+	identifier
+	result() *OAuth2Result
+}
+
+func (res *OAuth2Result) result() *OAuth2Result {
+	return res
+}
+
+func (res *OAuth2Result) addError(err error) {
+	res.Errors = append(res.Errors, err)
+}
+
+// profileFunc fetches the profile of the user after the code has been
+// exchanged, client sends the access token with each request.
+type profileFunc func(client *http.Client) error
+
+// oauth2Handler is the handler shared by the OAuth2 providers. The
+// oauth.Config is built once when the handler is created.
+type oauth2Handler struct {
+	name          string
+	config        oauth.Config
+	redirectFunc  func(*http.Request) string
+	returnToHosts []string
+	stepUpScopes  []string
+	currentToken  func(*http.Request) *oauth.Token
+	currentSub    func(*http.Request) string
+	authParams    func(*http.Request) map[string]string
+	// newUser returns the provider struct mapped on a callback and the
+	// function that fetches its profile.
+	newUser func(*http.Request) (oauth2User, profileFunc)
+	// err is the result of validating the options, returned by
+	// validHandler.
+	err error
+	// includeGrantedScopes is set for providers supporting Google's
+	// include_granted_scopes extension, which is sent on step-up.
+	includeGrantedScopes bool
+	// formPost is set for providers that post the callback with
+	// response_mode=form_post.
+	formPost bool
+	// clientSecret, if set, returns the client secret for each token
+	// request.
+	clientSecret func() (string, error)
+}
+
+// newOAuth2Handler returns the handler of the provider name. opts must be a
+// copy of the callers options with the provider endpoints set, as it is not
+// copied again, and err the result of validating it.
+func newOAuth2Handler(name string, opts *OAuth2Options, err error, newUser func(*http.Request) (oauth2User, profileFunc)) *oauth2Handler {
+	var params map[string]string
+	if opts.AuthParams != nil {
+		params = make(map[string]string, len(opts.AuthParams))
+		for k, v := range opts.AuthParams {
+			params[k] = v
+		}
+	}
+	return &oauth2Handler{
+		name: name,
+		config: oauth.Config{
+			ClientId:     opts.ClientID,
+			ClientSecret: opts.ClientSecret,
+			RedirectURL:  opts.RedirectURL,
+			Scope:        strings.Join(opts.Scopes, " "),
+			AuthURL:      opts.AuthURL,
+			TokenURL:     opts.TokenURL,
+			HTTPClient:   opts.HTTPClient,
+			Retry:        opts.Retry,
+			AuthParams:   params,
+		},
+		redirectFunc:  opts.RedirectFunc,
+		returnToHosts: opts.ReturnToHosts,
+		stepUpScopes:  opts.StepUpScopes,
+		currentToken:  opts.CurrentToken,
+		currentSub:    opts.CurrentSubject,
+		authParams:    opts.AuthParamsFunc,
+		newUser:       newUser,
+		err:           err,
+	}
+}
+
+// handler returns the martini handler, even if the options are not valid.
+func (h *oauth2Handler) handler() martini.Handler {
+	return h.serve
+}
+
+// validHandler returns the martini handler, or the error if the options
+// are not valid.
+func (h *oauth2Handler) validHandler() (martini.Handler, error) {
+	if h.err != nil {
+		return nil, h.err
+	}
+	return h.serve, nil
+}
+
+// serve redirects login requests to the provider and handles the callback
+// on the path of the redirect URL.
+func (h *oauth2Handler) serve(r *http.Request, w http.ResponseWriter, c martini.Context) {
+	transport := h.transport(r)
+	if h.redirectFunc != nil && transport.Config.RedirectURL == "" {
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	cbPath := ""
+	if u, err := url.Parse(transport.Config.RedirectURL); err == nil {
+		cbPath = u.Path
+	}
+	if r.URL.Path != cbPath {
+		h.login(w, r, transport)
+		return
+	}
+	h.callback(w, r, c, transport)
+}
+
+// transport returns a Transport for req using a copy of the config, with
+// the redirect URL resolved from RedirectFunc if it is set.
+func (h *oauth2Handler) transport(req *http.Request) *oauth.Transport {
+	config := h.config
+	if h.redirectFunc != nil {
+		config.RedirectURL = h.redirectFunc(req)
 	}
-	if opts.RedirectFunc != nil {
-		config.RedirectURL = opts.RedirectFunc(req)
+	return &oauth.Transport{Config: &config}
+}
+
+// login redirects r to the authorization URL of the provider, adding the
+// scopes in the scope parameter of r if they are allowed by StepUpScopes.
+func (h *oauth2Handler) login(w http.ResponseWriter, r *http.Request, transport *oauth.Transport) {
+	stepUp := false
+	if extra := r.FormValue("scope"); extra != "" && len(h.stepUpScopes) > 0 {
+		scopes := strings.Fields(transport.Config.Scope)
+		for _, scope := range strings.Fields(extra) {
+			if !containsString(h.stepUpScopes, scope) {
+				http.Error(w, "Bad Request", http.StatusBadRequest)
+				return
+			}
+			if !containsString(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+		transport.Config.Scope = strings.Join(scopes, " ")
+		transport.Config.IncludeGrantedScopes = h.includeGrantedScopes
+		stepUp = true
 	}
+	state := setOAuthState(w, r, h.name, transport.Config.RedirectURL, h.formPost, stepUp)
+	http.Redirect(w, r, h.authCodeURL(r, transport.Config, state), http.StatusFound)
+}
 
-	return &oauth.Transport{
-		Config:    config,
-		Transport: http.DefaultTransport,
+// authCodeURL returns the authorization URL for the login request r, with
+// the parameters from AuthParamsFunc added to config.
+func (h *oauth2Handler) authCodeURL(r *http.Request, config *oauth.Config, state string) string {
+	if h.authParams != nil || h.formPost {
+		params := make(map[string]string)
+		for k, v := range config.AuthParams {
+			params[k] = v
+		}
+		if h.authParams != nil {
+			for k, v := range h.authParams(r) {
+				params[k] = v
+			}
+		}
+		if h.formPost {
+			params["response_mode"] = "form_post"
+		}
+		config.AuthParams = params
+	}
+	return config.AuthCodeURL(state)
+}
+
+// callback verifies the state, exchanges the code and fetches the profile
+// of the user, then maps the provider struct and its Identity.
+func (h *oauth2Handler) callback(w http.ResponseWriter, r *http.Request, c martini.Context, transport *oauth.Transport) {
+	user, profile := h.newUser(r)
+	res := user.result()
+	defer mapWithIdentity(c, user)
+	returnTo, stepUp, err := verifyOAuthState(w, r, h.name, h.returnToHosts)
+	if err != nil {
+		res.Errors = append(res.Errors, err)
+		return
+	}
+	res.ReturnTo = returnTo
+	if e := r.FormValue("error"); e != "" {
+		res.Errors = append(res.Errors, errors.New(h.name+" returned error: "+e))
+		return
+	}
+	if h.clientSecret != nil {
+		secret, err := h.clientSecret()
+		if err != nil {
+			res.Errors = append(res.Errors, err)
+			return
+		}
+		transport.Config.ClientSecret = secret
+	}
+	tk, err := transport.Exchange(r.FormValue("code"))
+	if err != nil {
+		res.Errors = append(res.Errors, err)
+		return
+	}
+	res.Token = tk
+	res.AccessToken = tk.AccessToken
+	res.RefreshToken = tk.RefreshToken
+	if err := profile(transport.Client()); err != nil {
+		res.Errors = append(res.Errors, err)
+		return
+	}
+	if !stepUp {
+		return
+	}
+	if err := h.stepUp(r, user); err != nil {
+		res.Errors = append(res.Errors, err)
+	}
+}
+
+// getProfile gets the JSON document at url into v and returns the raw
+// response. Responses without a 2xx status are an error.
+func getProfile(client *http.Client, url string, v interface{}) (json.RawMessage, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, errors.New("profile request failed: " + resp.Status)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+// stepUp merges the new token of user into a copy of the token returned by
+// CurrentToken, if any, once the profile shows it is for the same account.
+// It is only called on the callback of a login that requested step-up
+// scopes.
+// The scopes the current token did not have are set as NewScopes if the
+// token response listed the granted scopes.
+func (h *oauth2Handler) stepUp(r *http.Request, user oauth2User) error {
+	if h.currentToken == nil {
+		return nil
+	}
+	current := h.currentToken(r)
+	if current == nil {
+		return nil
+	}
+	if sub := user.Identity().Subject; sub == "" || h.currentSub == nil || sub != h.currentSub(r) {
+		return ErrStepUpAccount
+	}
+	res := user.result()
+	tk := *current
+	tk.Extra = make(map[string]string, len(current.Extra)+len(res.Token.Extra))
+	for k, v := range current.Extra {
+		tk.Extra[k] = v
+	}
+	for k, v := range res.Token.Extra {
+		tk.Extra[k] = v
+	}
+	tk.AccessToken = res.Token.AccessToken
+	tk.Expiry = res.Token.Expiry
+	if res.Token.RefreshToken != "" {
+		tk.RefreshToken = res.Token.RefreshToken
+	}
+	if res.Token.Extra["scope"] != "" {
+		granted := current.Scopes()
+		for _, scope := range res.Token.Scopes() {
+			if !containsString(granted, scope) {
+				res.NewScopes = append(res.NewScopes, scope)
+			}
+		}
+	}
+	res.Token = &tk
+	res.RefreshToken = tk.RefreshToken
+	return nil
+}
+
+// decodeIDToken decodes the claims of an OpenID Connect id_token into v. The
+// signature is not checked, so it must only be used on tokens received
+// directly from the token endpoint over TLS.
+func decodeIDToken(idToken string, v interface{}) error {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed id_token")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
 	}
+	return false
 }
@@ -0,0 +1,324 @@
+package dmv
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/go-martini/martini"
+	"github.com/tomsteele/dmv/oauth"
+)
+
+// fakeProvider starts a server for the endpoints of a provider and points
+// each URL variable in urls at ts.URL followed by its path until the test
+// ends. Each route is either a response body, sent as JSON if it looks
+// like JSON, or an http.HandlerFunc. Other paths are not found.
+func fakeProvider(t *testing.T, routes map[string]interface{}, urls map[*string]string) *httptest.Server {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch route := routes[r.URL.EscapedPath()].(type) {
+		case string:
+			if strings.HasPrefix(route, "{") || strings.HasPrefix(route, "[") {
+				w.Header().Set("Content-Type", "application/json")
+			}
+			fmt.Fprint(w, route)
+		case http.HandlerFunc:
+			route(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(ts.Close)
+	for v, path := range urls {
+		old := *v
+		*v = ts.URL + path
+		v := v
+		t.Cleanup(func() { *v = old })
+	}
+	return ts
+}
+
+// testOAuth2Options returns valid options for the provider name with the
+// callback at /auth/callback/name.
+func testOAuth2Options(name string) OAuth2Options {
+	return OAuth2Options{
+		ClientID:     "client_id",
+		ClientSecret: "client_secret",
+		RedirectURL:  "http://localhost/auth/callback/" + name,
+	}
+}
+
+// serveCallback sends a callback request with a code and valid state for
+// the provider name to handler, followed by mapped, which receives the
+// provider struct.
+func serveCallback(name string, handler martini.Handler, mapped interface{}) {
+	m := testMartini()
+	m.Get("/auth/callback/"+name, handler, mapped)
+	r, _ := http.NewRequest("GET", "/auth/callback/"+name+"?code=c0d3", nil)
+	addOAuthState(r, name)
+	m.ServeHTTP(httptest.NewRecorder(), r)
+}
+
+func Test_RedirectRelativeFuncWithOptions(t *testing.T) {
+	if _, err := RedirectRelativeFuncWithOptions("/cb", &RedirectRelativeOptions{}); err == nil {
+		t.Error("expected error without hosts")
+	}
+	if _, err := RedirectRelativeFuncWithOptions("/cb", &RedirectRelativeOptions{Hosts: []string{"a"}, TrustedProxies: []string{"nope"}}); err == nil {
+		t.Error("expected error for invalid proxy")
+	}
+	redirect, err := RedirectRelativeFuncWithOptions("/cb", &RedirectRelativeOptions{
+		Hosts:          []string{"example.com", "localhost:3000"},
+		TrustedProxies: []string{"10.0.0.0/8", "::1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name    string
+		host    string
+		remote  string
+		tls     bool
+		headers map[string]string
+		want    string
+	}{
+		{"direct", "example.com", "203.0.113.1:1234", false, nil, "http://example.com/cb"},
+		{"direct tls", "EXAMPLE.com", "203.0.113.1:1234", true, nil, "https://EXAMPLE.com/cb"},
+		{"unknown host", "evil.com", "203.0.113.1:1234", false, nil, ""},
+		{"untrusted forwarded host", "example.com", "203.0.113.1:1234", false, map[string]string{"X-Forwarded-Host": "evil.com", "X-Forwarded-Proto": "https"}, "http://example.com/cb"},
+		{"untrusted ssl header", "example.com", "203.0.113.1:1234", false, map[string]string{"X-SSL-Request": "on"}, "http://example.com/cb"},
+		{"trusted proxy", "internal:8080", "10.1.2.3:1234", false, map[string]string{"X-Forwarded-Host": "example.com", "X-Forwarded-Proto": "https"}, "https://example.com/cb"},
+		{"trusted proxy list", "internal:8080", "10.1.2.3:1234", false, map[string]string{"X-Forwarded-Host": "evil.com, example.com"}, "http://example.com/cb"},
+		{"trusted proxy ipv6", "internal:8080", "[::1]:1234", false, map[string]string{"X-SSL-Request": "on", "X-Forwarded-Host": "localhost:3000"}, "https://localhost:3000/cb"},
+		{"trusted proxy unknown host", "example.com", "10.1.2.3:1234", false, map[string]string{"X-Forwarded-Host": "evil.com"}, ""},
+		{"forwarded", "internal", "10.1.2.3:1234", false, map[string]string{"Forwarded": `for=192.0.2.1;host="evil.com", for=192.0.2.60;proto=https;host=example.com`, "X-Forwarded-Host": "evil.com"}, "https://example.com/cb"},
+		{"untrusted forwarded", "example.com", "203.0.113.1:1234", false, map[string]string{"Forwarded": "proto=https;host=evil.com"}, "http://example.com/cb"},
+	} {
+		req, _ := http.NewRequest("GET", "/auth/github", nil)
+		req.Host = tt.host
+		req.RemoteAddr = tt.remote
+		if tt.tls {
+			req.TLS = &tls.ConnectionState{}
+		}
+		for k, v := range tt.headers {
+			req.Header.Set(k, v)
+		}
+		if got := redirect(req); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func Test_RedirectFuncFailsClosed(t *testing.T) {
+	redirect, _ := RedirectRelativeFuncWithOptions("/auth/callback/github", &RedirectRelativeOptions{Hosts: []string{"example.com"}})
+	m := testMartini()
+	m.Get("/auth/github", AuthGithub(&OAuth2Options{ClientID: "client_id", ClientSecret: "client_secret", RedirectFunc: redirect}))
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/github", nil)
+	req.Host = "evil.com"
+	m.ServeHTTP(res, req)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for unknown host, got %d %s", res.Code, res.Header().Get("Location"))
+	}
+	res = httptest.NewRecorder()
+	req.Host = "example.com"
+	m.ServeHTTP(res, req)
+	if res.Code != http.StatusFound {
+		t.Errorf("expected redirect for allowed host, got %d", res.Code)
+	}
+}
+
+func Test_OAuth2OptionsValidate(t *testing.T) {
+	for _, tt := range []struct {
+		opts OAuth2Options
+		ok   bool
+	}{
+		{OAuth2Options{ClientID: "id", ClientSecret: "secret", RedirectURL: "http://localhost/cb"}, true},
+		{OAuth2Options{ClientID: "id", ClientSecret: "secret", RedirectFunc: RedirectRelativeFunc("/cb")}, true},
+		{OAuth2Options{ClientSecret: "secret", RedirectURL: "http://localhost/cb"}, false},
+		{OAuth2Options{ClientID: "id", RedirectURL: "http://localhost/cb"}, false},
+		{OAuth2Options{ClientID: "id", ClientSecret: "secret"}, false},
+	} {
+		if err := tt.opts.Validate(); (err == nil) != tt.ok {
+			t.Errorf("%+v: got %v, want ok=%v", tt.opts, err, tt.ok)
+		}
+	}
+	if _, err := NewAuthGithub(&OAuth2Options{}); err == nil {
+		t.Error("expected NewAuthGithub to return an error for invalid options")
+	}
+	if _, err := NewAuthTwitter(&OAuth1Options{}); err == nil {
+		t.Error("expected NewAuthTwitter to return an error for invalid options")
+	}
+	if h, err := NewAuthGithub(&OAuth2Options{ClientID: "id", ClientSecret: "secret", RedirectURL: "http://localhost/cb"}); err != nil || h == nil {
+		t.Errorf("NewAuthGithub with valid options: %v", err)
+	}
+	AuthGithub(&OAuth2Options{})
+}
+
+func Test_OAuth2OptionsNotModified(t *testing.T) {
+	opts := &OAuth2Options{ClientID: "id", ClientSecret: "secret", RedirectURL: "http://localhost/cb"}
+	AuthGithub(opts)
+	AuthGoogle(opts)
+	AuthDiscord(&DiscordOptions{OAuth2Options: *opts})
+	if opts.AuthURL != "" || opts.TokenURL != "" || opts.Scopes != nil {
+		t.Errorf("options were modified: %+v", opts)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// fakeClient returns a client that sends every request to a fakeProvider
+// serving routes, and a function returning the host and path of each
+// request made with it.
+func fakeClient(t *testing.T, routes map[string]interface{}) (*http.Client, func() []string) {
+	ts := fakeProvider(t, routes, nil)
+	u, _ := url.Parse(ts.URL)
+	var paths []string
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		paths = append(paths, r.URL.Host+r.URL.Path)
+		r = r.Clone(r.Context())
+		r.URL.Scheme, r.URL.Host = u.Scheme, u.Host
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	return client, func() []string { return paths }
+}
+
+func Test_OAuth2HTTPClient(t *testing.T) {
+	client, paths := fakeClient(t, map[string]interface{}{
+		"/login/oauth/access_token": `{"access_token":"token"}`,
+		"/user":                     `{"id":1,"login":"gopher"}`,
+	})
+	opts := testOAuth2Options("github")
+	opts.HTTPClient = client
+	var g *Github
+	serveCallback("github", AuthGithub(&opts), func(gg *Github) { g = gg })
+	if len(g.Errors) > 0 {
+		t.Fatal(g.Errors)
+	}
+	if g.Profile.Login != "gopher" {
+		t.Errorf("Profile not mapped, got %+v", g.Profile)
+	}
+	want := []string{"github.com/login/oauth/access_token", "api.github.com/user"}
+	if got := paths(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected requests %v through HTTPClient, got %v", want, got)
+	}
+}
+
+func Test_StepUpScopes(t *testing.T) {
+	opts := &OAuth2Options{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/callback/google",
+		Scopes:       []string{"email"},
+		StepUpScopes: []string{"drive"},
+	}
+	m := testMartini()
+	m.Get("/auth/google", AuthGoogle(opts))
+
+	res := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/google?scope=drive", nil)
+	m.ServeHTTP(res, r)
+	loc, _ := url.Parse(res.HeaderMap.Get("Location"))
+	if q := loc.Query(); q.Get("scope") != "email drive" || q.Get("include_granted_scopes") != "true" {
+		t.Errorf("step-up scopes not requested, got %s", loc)
+	}
+
+	res = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/auth/google?scope=drive+admin", nil)
+	m.ServeHTTP(res, r)
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for a scope not in StepUpScopes, got %d", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	r, _ = http.NewRequest("GET", "/auth/google", nil)
+	m.ServeHTTP(res, r)
+	loc, _ = url.Parse(res.HeaderMap.Get("Location"))
+	if q := loc.Query(); q.Get("scope") != "email" || q.Get("include_granted_scopes") != "" {
+		t.Errorf("login should only request Scopes, got %s", loc)
+	}
+}
+
+func Test_StepUpMergesToken(t *testing.T) {
+	client, _ := fakeClient(t, map[string]interface{}{
+		"/login/oauth/access_token": `{"access_token":"token2","scope":"user:email,repo"}`,
+		"/user":                     `{"id":1,"login":"gopher"}`,
+	})
+	current := &oauth.Token{AccessToken: "token1", RefreshToken: "refresh1", Extra: map[string]string{"scope": "user:email"}}
+	opts := &OAuth2Options{
+		ClientID:     "id",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost/auth/callback/github",
+		HTTPClient:   client,
+		StepUpScopes: []string{"repo"},
+		CurrentToken: func(*http.Request) *oauth.Token { return current },
+		CurrentSubject: func(r *http.Request) string {
+			return r.Header.Get("X-Subject")
+		},
+	}
+	var g *Github
+	m := testMartini()
+	m.Get("/auth/github", AuthGithub(opts))
+	m.Get("/auth/callback/github", AuthGithub(opts), func(gg *Github) {
+		g = gg
+	})
+	callback := func(subject, scope string) {
+		res := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/auth/github?scope="+scope, nil)
+		m.ServeHTTP(res, r)
+		loc, _ := url.Parse(res.HeaderMap.Get("Location"))
+		r, _ = http.NewRequest("GET", "/auth/callback/github?code=c0d3&state="+loc.Query().Get("state"), nil)
+		r.Header.Set("X-Subject", subject)
+		r.AddCookie(res.Result().Cookies()[0])
+		m.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	callback("2", "")
+	if len(g.Errors) > 0 || g.Token.RefreshToken != "" || g.NewScopes != nil {
+		t.Errorf("token merged without a step-up, got %v, %+v", g.Errors, g.Token)
+	}
+
+	callback("2", "repo")
+	if len(g.Errors) != 1 || g.Errors[0] != ErrStepUpAccount {
+		t.Errorf("expected ErrStepUpAccount for another account, got %v", g.Errors)
+	}
+	if g.Token.RefreshToken != "" || g.NewScopes != nil {
+		t.Errorf("token of another account was merged, got %+v", g.Token)
+	}
+
+	callback("1", "repo")
+	if len(g.Errors) > 0 {
+		t.Fatal(g.Errors)
+	}
+	if g.AccessToken != "token2" || g.Token.RefreshToken != "refresh1" {
+		t.Errorf("token not merged, got %+v", g.Token)
+	}
+	if len(g.NewScopes) != 1 || g.NewScopes[0] != "repo" {
+		t.Errorf("expected new scope repo, got %v", g.NewScopes)
+	}
+	if current.AccessToken != "token1" || current.Extra["scope"] != "user:email" {
+		t.Errorf("current token was modified: %+v", current)
+	}
+}
+
+func Test_ProfileStatusChecked(t *testing.T) {
+	client, _ := fakeClient(t, map[string]interface{}{
+		"/login/oauth/access_token": `{"access_token":"token"}`,
+		"/user": http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, `{"id":1,"login":"gopher"}`, http.StatusUnauthorized)
+		}),
+	})
+	opts := testOAuth2Options("github")
+	opts.HTTPClient = client
+	var id *Identity
+	serveCallback("github", AuthGithub(&opts), func(i *Identity) { id = i })
+	if len(id.Errors) != 1 || id.Subject != "" {
+		t.Errorf("expected only the profile status error, got %v for %q", id.Errors, id.Subject)
+	}
+}
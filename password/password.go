@@ -0,0 +1,289 @@
+// Package password hashes and verifies passwords using argon2id, bcrypt or
+// scrypt. Hashes are stored as PHC strings, such as
+//
+//	$argon2id$v=19$m=65536,t=3,p=4$c2FsdHNhbHRzYWx0c2FsdA$aGFzaGhhc2hoYXNoaGFzaGhhc2hoYXNoaGFzaGhhc2g
+//	$scrypt$ln=15,r=8,p=1$c2FsdHNhbHRzYWx0c2FsdA$aGFzaGhhc2hoYXNoaGFzaGhhc2hoYXNoaGFzaGhhc2g
+//	$2a$12$R9h/cIPz0gi.URNNX3kh2OPST9/PgBkqquzi.Ss7KIUgO2t0jWMUW
+//
+// so the algorithm and parameters are kept with each hash. Verify reports
+// when a hash was made with an algorithm or parameters that differ from the
+// Policy, so it can be replaced after a successful login.
+//
+//	hash, err := password.Hash("correct horse battery staple")
+//	...
+//	ok, rehash, err := password.Verify(hash, attempt)
+//	if ok && rehash {
+//		newHash, _ := password.Hash(attempt)
+//		// Store newHash.
+//	}
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// The supported algorithms.
+const (
+	Argon2id = "argon2id"
+	Bcrypt   = "bcrypt"
+	Scrypt   = "scrypt"
+)
+
+// The largest argon2id memory, in KiB, and iterations, and the largest
+// scrypt memory, in bytes, and parallelism accepted from a hash unless the
+// Policy uses more, so a corrupt or malicious hash cannot exhaust the memory
+// or CPU of the server.
+const (
+	maxArgon2idMemory     = 1 << 20
+	maxArgon2idIterations = 16
+	maxScryptMemory       = 1 << 30
+	maxScryptParallelism  = 16
+)
+
+var (
+	// ErrInvalidHash is returned when a hash is not in a recognized format
+	// or its parameters are out of range.
+	ErrInvalidHash = errors.New("password: invalid hash")
+	// ErrUnsupportedAlgorithm is returned when a Policy or hash uses an
+	// unknown algorithm.
+	ErrUnsupportedAlgorithm = errors.New("password: unsupported algorithm")
+)
+
+// Argon2idParams are the parameters for argon2id hashes.
+type Argon2idParams struct {
+	// Memory in KiB.
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// ScryptParams are the parameters for scrypt hashes. N must be a power of
+// two greater than one.
+type ScryptParams struct {
+	N          int
+	R          int
+	P          int
+	SaltLength int
+	KeyLength  int
+}
+
+// BcryptParams are the parameters for bcrypt hashes.
+type BcryptParams struct {
+	Cost int
+}
+
+// Policy selects the algorithm and parameters used for new hashes.
+type Policy struct {
+	// Algorithm is one of Argon2id, Bcrypt or Scrypt.
+	Algorithm string
+	Argon2id  Argon2idParams
+	Scrypt    ScryptParams
+	Bcrypt    BcryptParams
+}
+
+// DefaultPolicy uses argon2id with the second recommended option from RFC
+// 9106. Hash and Verify use it.
+var DefaultPolicy = &Policy{
+	Algorithm: Argon2id,
+	Argon2id: Argon2idParams{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 4,
+		SaltLength:  16,
+		KeyLength:   32,
+	},
+	Scrypt: ScryptParams{
+		N:          1 << 15,
+		R:          8,
+		P:          1,
+		SaltLength: 16,
+		KeyLength:  32,
+	},
+	Bcrypt: BcryptParams{
+		Cost: 12,
+	},
+}
+
+// Hash hashes password using DefaultPolicy.
+func Hash(password string) (string, error) {
+	return DefaultPolicy.Hash(password)
+}
+
+// Verify compares password to hash using DefaultPolicy.
+func Verify(hash, password string) (ok, rehash bool, err error) {
+	return DefaultPolicy.Verify(hash, password)
+}
+
+// Hash hashes password with a random salt and returns a PHC string.
+func (p *Policy) Hash(password string) (string, error) {
+	switch p.Algorithm {
+	case Argon2id:
+		a := p.Argon2id
+		salt, err := salt(int(a.SaltLength))
+		if err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, a.Memory, a.Iterations, a.Parallelism, b64(salt), b64(key)), nil
+	case Scrypt:
+		s := p.Scrypt
+		salt, err := salt(s.SaltLength)
+		if err != nil {
+			return "", err
+		}
+		key, err := scrypt.Key([]byte(password), salt, s.N, s.R, s.P, s.KeyLength)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d$%s$%s", log2(s.N), s.R, s.P, b64(salt), b64(key)), nil
+	case Bcrypt:
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), p.Bcrypt.Cost)
+		return string(hash), err
+	}
+	return "", ErrUnsupportedAlgorithm
+}
+
+// Verify compares password to hash in constant time. rehash is true when ok
+// is true and hash was made with a different algorithm or different
+// parameters than p, in which case the password should be hashed again
+// and the stored hash replaced.
+func (p *Policy) Verify(hash, password string) (ok, rehash bool, err error) {
+	if strings.HasPrefix(hash, "$2a$") || strings.HasPrefix(hash, "$2b$") || strings.HasPrefix(hash, "$2y$") {
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return false, false, ErrInvalidHash
+		}
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			if err == bcrypt.ErrMismatchedHashAndPassword {
+				return false, false, nil
+			}
+			return false, false, err
+		}
+		return true, p.Algorithm != Bcrypt || cost != p.Bcrypt.Cost, nil
+	}
+	parts := strings.Split(hash, "$")
+	switch {
+	case len(parts) == 6 && parts[1] == Argon2id:
+		var version int
+		var a Argon2idParams
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return false, false, ErrInvalidHash
+		}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &a.Memory, &a.Iterations, &a.Parallelism); err != nil || !p.argon2idInRange(a) {
+			return false, false, ErrInvalidHash
+		}
+		salt, key, err := decodeSaltKey(parts[4], parts[5])
+		if err != nil {
+			return false, false, err
+		}
+		a.SaltLength, a.KeyLength = uint32(len(salt)), uint32(len(key))
+		computed := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+		if subtle.ConstantTimeCompare(key, computed) != 1 {
+			return false, false, nil
+		}
+		return true, p.Algorithm != Argon2id || a != p.Argon2id, nil
+	case len(parts) == 5 && parts[1] == Scrypt:
+		var ln uint
+		var s ScryptParams
+		if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &ln, &s.R, &s.P); err != nil || !p.scryptInRange(ln, s) {
+			return false, false, ErrInvalidHash
+		}
+		s.N = 1 << ln
+		salt, key, err := decodeSaltKey(parts[3], parts[4])
+		if err != nil {
+			return false, false, err
+		}
+		s.SaltLength, s.KeyLength = len(salt), len(key)
+		computed, err := scrypt.Key([]byte(password), salt, s.N, s.R, s.P, s.KeyLength)
+		if err != nil {
+			return false, false, ErrInvalidHash
+		}
+		if subtle.ConstantTimeCompare(key, computed) != 1 {
+			return false, false, nil
+		}
+		return true, p.Algorithm != Scrypt || s != p.Scrypt, nil
+	case len(parts) > 1 && parts[0] == "":
+		return false, false, ErrUnsupportedAlgorithm
+	}
+	return false, false, ErrInvalidHash
+}
+
+// argon2idInRange reports whether the parameters of a hash are safe to
+// compute: t and p must be at least one, m at least 8*p KiB, and m and t no
+// larger than maxArgon2idMemory and maxArgon2idIterations, or the
+// parameters of p if those are larger.
+func (p *Policy) argon2idInRange(a Argon2idParams) bool {
+	maxMemory, maxIterations := uint32(maxArgon2idMemory), uint32(maxArgon2idIterations)
+	if p.Argon2id.Memory > maxMemory {
+		maxMemory = p.Argon2id.Memory
+	}
+	if p.Argon2id.Iterations > maxIterations {
+		maxIterations = p.Argon2id.Iterations
+	}
+	return a.Iterations >= 1 && a.Iterations <= maxIterations &&
+		a.Parallelism >= 1 &&
+		a.Memory >= 8*uint32(a.Parallelism) && a.Memory <= maxMemory
+}
+
+// scryptInRange reports whether the parameters of a hash are safe to
+// compute: ln must be between 1 and 30, r and p at least one, the memory
+// used, 128*N*r bytes, no larger than maxScryptMemory and p no larger than
+// maxScryptParallelism, or the parameters of p if those are larger.
+func (p *Policy) scryptInRange(ln uint, s ScryptParams) bool {
+	if ln < 1 || ln > 30 || s.R < 1 || s.P < 1 {
+		return false
+	}
+	maxMemory, maxParallelism := uint64(maxScryptMemory), maxScryptParallelism
+	if p.Scrypt.N > 0 && p.Scrypt.R > 0 && 128*uint64(p.Scrypt.N)*uint64(p.Scrypt.R) > maxMemory {
+		maxMemory = 128 * uint64(p.Scrypt.N) * uint64(p.Scrypt.R)
+	}
+	if p.Scrypt.P > maxParallelism {
+		maxParallelism = p.Scrypt.P
+	}
+	return s.P <= maxParallelism &&
+		uint64(s.R) <= maxMemory/128 && 128*(uint64(1)<<ln)*uint64(s.R) <= maxMemory
+}
+
+func decodeSaltKey(salt, key string) ([]byte, []byte, error) {
+	s, err := base64.RawStdEncoding.DecodeString(salt)
+	if err != nil {
+		return nil, nil, ErrInvalidHash
+	}
+	k, err := base64.RawStdEncoding.DecodeString(key)
+	if err != nil || len(k) == 0 {
+		return nil, nil, ErrInvalidHash
+	}
+	return s, k, nil
+}
+
+func salt(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func b64(b []byte) string {
+	return base64.RawStdEncoding.EncodeToString(b)
+}
+
+func log2(n int) int {
+	l := 0
+	for n > 1 {
+		n >>= 1
+		l++
+	}
+	return l
+}
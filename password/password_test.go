@@ -0,0 +1,104 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testPolicy = &Policy{
+	Algorithm: Argon2id,
+	Argon2id:  Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 8, KeyLength: 16},
+	Scrypt:    ScryptParams{N: 16, R: 1, P: 1, SaltLength: 8, KeyLength: 16},
+	Bcrypt:    BcryptParams{Cost: bcrypt.MinCost},
+}
+
+func TestHashVerify(t *testing.T) {
+	for _, tt := range []struct{ algorithm, prefix string }{
+		{Argon2id, "$argon2id$v=19$m=64,t=1,p=1$"},
+		{Scrypt, "$scrypt$ln=4,r=1,p=1$"},
+		{Bcrypt, "$2a$04$"},
+	} {
+		p := *testPolicy
+		p.Algorithm = tt.algorithm
+		hash, err := p.Hash("hunter2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(hash, tt.prefix) {
+			t.Errorf("%s: expected prefix %q, got %q", tt.algorithm, tt.prefix, hash)
+		}
+		if ok, rehash, err := p.Verify(hash, "hunter2"); !ok || rehash || err != nil {
+			t.Errorf("%s: Verify(correct) = %v, %v, %v", tt.algorithm, ok, rehash, err)
+		}
+		if ok, _, err := p.Verify(hash, "hunter3"); ok || err != nil {
+			t.Errorf("%s: Verify(wrong) = %v, %v", tt.algorithm, ok, err)
+		}
+	}
+}
+
+func TestVerifyRehash(t *testing.T) {
+	hash, _ := testPolicy.Hash("hunter2")
+
+	stronger := *testPolicy
+	stronger.Argon2id.Iterations = 2
+	if ok, rehash, _ := stronger.Verify(hash, "hunter2"); !ok || !rehash {
+		t.Errorf("expected rehash for outdated parameters, got ok=%v rehash=%v", ok, rehash)
+	}
+	if ok, rehash, _ := stronger.Verify(hash, "hunter3"); ok || rehash {
+		t.Errorf("expected no rehash for wrong password, got ok=%v rehash=%v", ok, rehash)
+	}
+
+	scrypt := *testPolicy
+	scrypt.Algorithm = Scrypt
+	if ok, rehash, _ := scrypt.Verify(hash, "hunter2"); !ok || !rehash {
+		t.Errorf("expected rehash for different algorithm, got ok=%v rehash=%v", ok, rehash)
+	}
+
+	bc, _ := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost+1)
+	if ok, rehash, _ := testPolicy.Verify(string(bc), "hunter2"); !ok || !rehash {
+		t.Errorf("expected rehash for bcrypt hash, got ok=%v rehash=%v", ok, rehash)
+	}
+}
+
+func TestVerifyKnownHashes(t *testing.T) {
+	for _, tt := range []struct{ hash, password string }{
+		// From the argon2 reference implementation.
+		{"$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc", "password"},
+		// RFC 7914 section 12, with a truncated key.
+		{"$scrypt$ln=4,r=1,p=1$$d9ZXYjhleyA7GcpCwYoEl/FrSETjB0ro39/6P+3iFEI", ""},
+	} {
+		if ok, _, err := testPolicy.Verify(tt.hash, tt.password); !ok || err != nil {
+			t.Errorf("Verify(%q) = %v, %v", tt.hash, ok, err)
+		}
+	}
+}
+
+func TestVerifyInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		hash string
+		err  error
+	}{
+		{"", ErrInvalidHash},
+		{"plaintext", ErrInvalidHash},
+		{"$argon2i$v=19$m=64,t=1,p=1$c2FsdA$aGFzaA", ErrUnsupportedAlgorithm},
+		{"$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$argon2id$v=19$m=64,t=1,p=1$c2FsdA$!!!", ErrInvalidHash},
+		{"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$argon2id$v=19$m=64,t=1,p=0$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$argon2id$v=19$m=4,t=1,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$argon2id$v=19$m=4294967295,t=1,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$argon2id$v=19$m=64,t=4294967295,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$scrypt$ln=0,r=1,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$scrypt$ln=30,r=8,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$scrypt$ln=15,r=1099511627776,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$scrypt$ln=15,r=8,p=1000000$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$scrypt$ln=15,r=8,p=0$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"$2a$xx$", ErrInvalidHash},
+	} {
+		if ok, _, err := testPolicy.Verify(tt.hash, "password"); ok || err != tt.err {
+			t.Errorf("Verify(%q) = %v, %v, want %v", tt.hash, ok, err, tt.err)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+// Package session provides optional login sessions stored in authenticated
+// and encrypted cookies, so an application using dmv does not need a
+// separate sessions middleware.
+//
+//    sessions, err := session.New(&session.Options{Keys: [][]byte{key}, Secure: true})
+//    if err != nil {
+//        log.Fatal(err)
+//    }
+//    m.Post("/login", dmv.AuthLocal(opts), func(l *dmv.Local, w http.ResponseWriter) {
+//        // Check l.Errors.
+//        sessions.Login(w, l.Username)
+//    })
+//    m.Get("/", sessions.RequireLogin(), func(s *session.Session) string {
+//        return "hi " + s.Identity
+//    })
+//
+// Sessions are not stored on the server, so Logout only removes the cookie
+// from the browser. Use short timeouts if this is a concern.
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+var (
+	// ErrNoSession is returned by Get when there is no valid session cookie.
+	ErrNoSession = errors.New("session: no session")
+	// ErrExpired is returned by Get when the session has passed its idle or
+	// absolute timeout.
+	ErrExpired = errors.New("session: session expired")
+)
+
+// Session is a logged in session. It is mapped to the request context by
+// RequireLogin.
+type Session struct {
+	// ID is random and changes on every Login, so a session ID set before
+	// login can not be used to fixate the session.
+	ID string `json:"id"`
+	// Identity identifies the logged in user, usually the application's
+	// user ID.
+	Identity string `json:"identity"`
+	// Created is the time of Login, used for the absolute timeout.
+	Created time.Time `json:"created"`
+	// LastSeen is the last time the session was used, used for the idle
+	// timeout.
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// Options are used to pass conditional arguments to New.
+type Options struct {
+	// Keys used to encrypt and authenticate cookies. Each must be 32
+	// bytes. The first is used for new cookies, the rest are only used to
+	// read cookies, which allows keys to be rotated.
+	Keys [][]byte
+	// The name of the cookie. Defaults to "dmv_session".
+	CookieName string
+	// The path of the cookie. Defaults to "/".
+	Path string
+	// The domain of the cookie. Defaults to the host of the request.
+	Domain string
+	// If true the cookie is only sent over HTTPS.
+	Secure bool
+	// How long a session lasts without being used. Defaults to 30 minutes.
+	IdleTimeout time.Duration
+	// How long a session lasts after Login regardless of use. Defaults to
+	// 24 hours.
+	AbsoluteTimeout time.Duration
+	// If set, RequireLogin redirects here instead of responding with a
+	// 401. The URL of GET requests is added as the return_to parameter, so
+	// the login handler can return the user to the page after login.
+	LoginURL string
+}
+
+// Manager creates and reads sessions.
+type Manager struct {
+	opts  Options
+	aeads []cipher.AEAD
+	now   func() time.Time
+}
+
+// New returns a Manager using opts. An error is returned if there are no
+// keys or a key is not 32 bytes.
+func New(opts *Options) (*Manager, error) {
+	m := &Manager{opts: *opts, now: time.Now}
+	if len(m.opts.Keys) == 0 {
+		return nil, errors.New("session: at least one key is required")
+	}
+	for _, key := range m.opts.Keys {
+		if len(key) != 32 {
+			return nil, errors.New("session: keys must be 32 bytes")
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		m.aeads = append(m.aeads, aead)
+	}
+	if m.opts.CookieName == "" {
+		m.opts.CookieName = "dmv_session"
+	}
+	if m.opts.Path == "" {
+		m.opts.Path = "/"
+	}
+	if m.opts.IdleTimeout == 0 {
+		m.opts.IdleTimeout = 30 * time.Minute
+	}
+	if m.opts.AbsoluteTimeout == 0 {
+		m.opts.AbsoluteTimeout = 24 * time.Hour
+	}
+	return m, nil
+}
+
+// Login starts a new session for identity with a new ID and sets the
+// cookie on w. Any existing session is replaced.
+func (m *Manager) Login(w http.ResponseWriter, identity string) (*Session, error) {
+	id := make([]byte, 24)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := m.now()
+	s := &Session{
+		ID:       base64.RawURLEncoding.EncodeToString(id),
+		Identity: identity,
+		Created:  now,
+		LastSeen: now,
+	}
+	if err := m.save(w, s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Logout removes the session cookie.
+func (m *Manager) Logout(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    "",
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		MaxAge:   -1,
+		Secure:   m.opts.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// Get returns the session from the request cookie. It does not update
+// LastSeen, use RequireLogin for that.
+func (m *Manager) Get(req *http.Request) (*Session, error) {
+	cookie, err := req.Cookie(m.opts.CookieName)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil, ErrNoSession
+	}
+	for _, aead := range m.aeads {
+		if len(data) < aead.NonceSize() {
+			break
+		}
+		nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, []byte(m.opts.CookieName))
+		if err != nil {
+			continue
+		}
+		s := &Session{}
+		if err := json.Unmarshal(plaintext, s); err != nil {
+			return nil, ErrNoSession
+		}
+		now := m.now()
+		if now.Sub(s.LastSeen) > m.opts.IdleTimeout || now.Sub(s.Created) > m.opts.AbsoluteTimeout {
+			return nil, ErrExpired
+		}
+		return s, nil
+	}
+	return nil, ErrNoSession
+}
+
+// RequireLogin maps the current *Session to the request context. If there
+// is no valid session the cookie is removed and the request is redirected
+// to LoginURL, or a 401 is written if it is not set. The cookie is
+// refreshed as the session is used so it stays within the idle timeout.
+func (m *Manager) RequireLogin() martini.Handler {
+	return func(w http.ResponseWriter, req *http.Request, c martini.Context) {
+		s, err := m.Get(req)
+		if err != nil {
+			if err == ErrExpired {
+				m.Logout(w)
+			}
+			if m.opts.LoginURL != "" {
+				http.Redirect(w, req, m.loginURL(req), http.StatusFound)
+				return
+			}
+			http.Error(w, "Not Authorized", http.StatusUnauthorized)
+			return
+		}
+		// Only rewrite the cookie once a tenth of the idle timeout has
+		// passed, rather than on every request.
+		if now := m.now(); now.Sub(s.LastSeen) > m.opts.IdleTimeout/10 {
+			s.LastSeen = now
+			if err := m.save(w, s); err != nil {
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+		}
+		c.Map(s)
+	}
+}
+
+// loginURL returns LoginURL with the request URI of req added as return_to.
+// It is only added for GET and HEAD requests, which can be repeated after
+// login, and only if it is a local path so it can not be used as an open
+// redirect.
+func (m *Manager) loginURL(req *http.Request) string {
+	returnTo := req.URL.RequestURI()
+	if req.Method != "GET" && req.Method != "HEAD" {
+		return m.opts.LoginURL
+	}
+	if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") || strings.Contains(returnTo, "\\") {
+		return m.opts.LoginURL
+	}
+	u, err := url.Parse(m.opts.LoginURL)
+	if err != nil {
+		return m.opts.LoginURL
+	}
+	q := u.Query()
+	q.Set("return_to", returnTo)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// save encrypts s with the first key and sets it as the cookie on w. The
+// cookie expires with the absolute timeout.
+func (m *Manager) save(w http.ResponseWriter, s *Session) error {
+	plaintext, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	aead := m.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, []byte(m.opts.CookieName))
+	http.SetCookie(w, &http.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(sealed),
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		Expires:  s.Created.Add(m.opts.AbsoluteTimeout),
+		Secure:   m.opts.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
@@ -0,0 +1,186 @@
+package session
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-martini/martini"
+)
+
+func testManager(t *testing.T) *Manager {
+	m, err := New(&Options{Keys: [][]byte{bytes.Repeat([]byte("k"), 32)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func login(t *testing.T, m *Manager, identity string) (*Session, *http.Cookie) {
+	res := httptest.NewRecorder()
+	s, err := m.Login(res, identity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, res.Result().Cookies()[0]
+}
+
+func TestNewKeys(t *testing.T) {
+	if _, err := New(&Options{}); err == nil {
+		t.Error("expected error without keys")
+	}
+	if _, err := New(&Options{Keys: [][]byte{[]byte("short")}}); err == nil {
+		t.Error("expected error for short key")
+	}
+}
+
+func TestLoginGet(t *testing.T) {
+	m := testManager(t)
+	s, cookie := login(t, m, "gopher")
+	if !cookie.HttpOnly || cookie.Name != "dmv_session" {
+		t.Errorf("unexpected cookie %v", cookie)
+	}
+	if bytes.Contains([]byte(cookie.Value), []byte("gopher")) {
+		t.Error("cookie is not encrypted")
+	}
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	got, err := m.Get(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != s.ID || got.Identity != "gopher" {
+		t.Errorf("got %+v, want %+v", got, s)
+	}
+
+	s2, _ := login(t, m, "gopher")
+	if s2.ID == s.ID {
+		t.Error("expected a new session ID on login")
+	}
+
+	tampered := *cookie
+	b := []byte(tampered.Value)
+	b[len(b)-2] ^= 1
+	tampered.Value = string(b)
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(&tampered)
+	if _, err := m.Get(req); err != ErrNoSession {
+		t.Errorf("expected ErrNoSession for tampered cookie, got %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	old := testManager(t)
+	_, cookie := login(t, old, "gopher")
+	m, _ := New(&Options{Keys: [][]byte{bytes.Repeat([]byte("n"), 32), bytes.Repeat([]byte("k"), 32)}})
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	if s, err := m.Get(req); err != nil || s.Identity != "gopher" {
+		t.Errorf("expected cookie sealed with old key to be read, got %v, %v", s, err)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	m := testManager(t)
+	start := time.Now()
+	now := start
+	m.now = func() time.Time { return now }
+	_, cookie := login(t, m, "gopher")
+	get := func() error {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.AddCookie(cookie)
+		_, err := m.Get(req)
+		return err
+	}
+	now = start.Add(31 * time.Minute)
+	if err := get(); err != ErrExpired {
+		t.Errorf("expected idle timeout, got %v", err)
+	}
+
+	// Keep the session active so only the absolute timeout applies.
+	now = start
+	_, cookie = login(t, m, "gopher")
+	mux := martini.Classic()
+	mux.Get("/", m.RequireLogin(), func() {})
+	for now.Sub(start) < 25*time.Hour {
+		now = now.Add(20 * time.Minute)
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.AddCookie(cookie)
+		mux.ServeHTTP(res, req)
+		if res.Code != http.StatusOK {
+			if now.Sub(start) <= 24*time.Hour {
+				t.Fatalf("session ended early after %s", now.Sub(start))
+			}
+			return
+		}
+		if cookies := res.Result().Cookies(); len(cookies) == 1 {
+			cookie = cookies[0]
+		}
+	}
+	t.Error("expected absolute timeout")
+}
+
+func TestRequireLogin(t *testing.T) {
+	m := testManager(t)
+	mux := martini.Classic()
+	mux.Post("/login", func(w http.ResponseWriter) {
+		m.Login(w, "gopher")
+	})
+	mux.Post("/logout", func(w http.ResponseWriter) {
+		m.Logout(w)
+	})
+	mux.Get("/", m.RequireLogin(), func(s *Session) string {
+		return "hi " + s.Identity
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401 without session, got %d", res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/login", nil)
+	mux.ServeHTTP(res, req)
+	cookie := res.Result().Cookies()[0]
+
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	mux.ServeHTTP(res, req)
+	if res.Body.String() != "hi gopher" {
+		t.Errorf("expected hi gopher, got %q", res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/logout", nil)
+	mux.ServeHTTP(res, req)
+	if c := res.Result().Cookies()[0]; c.MaxAge >= 0 || c.Value != "" {
+		t.Errorf("expected logout to remove cookie, got %v", c)
+	}
+
+	m.opts.LoginURL = "/login"
+	res = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/", nil)
+	mux.ServeHTTP(res, req)
+	if res.Code != http.StatusFound || res.Header().Get("Location") != "/login?return_to=%2F" {
+		t.Errorf("expected redirect to /login, got %d %q", res.Code, res.Header().Get("Location"))
+	}
+
+	for path, want := range map[string]string{
+		"/?tab=1":      "/login?return_to=%2F%3Ftab%3D1",
+		"//evil.com/x": "/login",
+	} {
+		res = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/", nil)
+		req.URL.Opaque = path
+		mux.ServeHTTP(res, req)
+		if got := res.Header().Get("Location"); got != want {
+			t.Errorf("%s: expected redirect to %q, got %q", path, want, got)
+		}
+	}
+}
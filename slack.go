@@ -0,0 +1,127 @@
+package dmv
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/go-martini/martini"
+)
+
+var (
+	slackAuthURL    = "https://slack.com/openid/connect/authorize"
+	slackTokenURL   = "https://slack.com/api/openid.connect.token"
+	slackProfileURL = "https://slack.com/api/openid.connect.userInfo"
+)
+
+// SlackOptions contains options for authenticating with Slack.
+type SlackOptions struct {
+	OAuth2Options
+	// If not empty, only members of the workspace with this team ID are
+	// allowed to log in.
+	TeamID string
+}
+
+// Slack stores the access and refresh tokens along with the users profile.
+type Slack struct {
+	OAuth2Result
+	Profile SlackProfile
+}
+
+// SlackProfile stores information about the user from Sign in with Slack.
+type SlackProfile struct {
+	Sub           string `json:"sub"`
+	UserID        string `json:"https://slack.com/user_id"`
+	TeamID        string `json:"https://slack.com/team_id"`
+	TeamName      string `json:"https://slack.com/team_name"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	GivenName     string `json:"given_name"`
+	FamilyName    string `json:"family_name"`
+	Picture       string `json:"picture"`
+}
+
+// Identity returns the user as an Identity.
+func (sl *Slack) Identity() *Identity {
+	return &Identity{
+		Errors:        sl.Errors,
+		ReturnTo:      sl.ReturnTo,
+		Provider:      "slack",
+		Subject:       sl.Profile.Sub,
+		Email:         sl.Profile.Email,
+		EmailVerified: sl.Profile.EmailVerified,
+		Name:          sl.Profile.Name,
+		AvatarURL:     sl.Profile.Picture,
+		Claims:        profileClaims(sl.RawProfile, sl.Profile),
+	}
+}
+
+// AuthSlack authenticates users using Sign in with Slack (OpenID Connect).
+// After handling a callback request, a request is made to get the users
+// Slack profile and a Slack struct will be mapped to the current request
+// context. The "openid" scope is required, "email" and "profile" are
+// optional.
+//
+// This function should be called twice in each application, once on the login
+// handler and once on the callback handler.
+//
+//     slackOpts := &dmv.SlackOptions{
+//         OAuth2Options: dmv.OAuth2Options{
+//             ClientID:     "oauth_id",
+//             ClientSecret: "oauth_secret",
+//             RedirectURL:  "https://host:port/auth/callback/slack",
+//             Scopes:       []string{"openid", "email", "profile"},
+//         },
+//         TeamID: "T0123456",
+//     }
+//
+//     m.Get("/auth/slack", dmv.AuthSlack(slackOpts))
+//     m.Get("/auth/callback/slack", dmv.AuthSlack(slackOpts), func(sl *dmv.Slack, req *http.Request, w http.ResponseWriter) {
+//         // Handle any errors.
+//         if len(sl.Errors) > 0 {
+//             http.Error(w, "OAuth failure", http.StatusInternalServerError)
+//             return
+//         }
+//         // Do something in a database to create or find the user by the Slack user id.
+//         user := findOrCreateBySlackID(sl.Profile.TeamID, sl.Profile.UserID)
+//         s.Set("userID", user.ID)
+//         http.Redirect(w, req, "/", http.StatusFound)
+//     })
+func AuthSlack(opts *SlackOptions) martini.Handler {
+	return newSlack(opts).handler()
+}
+
+// NewAuthSlack is like AuthSlack, but returns an error if opts are not
+// valid.
+func NewAuthSlack(opts *SlackOptions) (martini.Handler, error) {
+	return newSlack(opts).validHandler()
+}
+
+func newSlack(opts *SlackOptions) *oauth2Handler {
+	o := *opts
+	o.AuthURL = slackAuthURL
+	o.TokenURL = slackTokenURL
+	return newOAuth2Handler("slack", &o.OAuth2Options, o.Validate(), func(*http.Request) (oauth2User, profileFunc) {
+		sl := &Slack{}
+		return sl, func(client *http.Client) error {
+			profile := struct {
+				SlackProfile
+				OK    bool   `json:"ok"`
+				Error string `json:"error"`
+			}{}
+			raw, err := getProfile(client, slackProfileURL, &profile)
+			sl.RawProfile = raw
+			if err != nil {
+				return err
+			}
+			if !profile.OK {
+				return errors.New("slack userInfo failed: " + profile.Error)
+			}
+			if o.TeamID != "" && profile.TeamID != o.TeamID {
+				return errors.New("slack team is not allowed")
+			}
+			sl.Profile = profile.SlackProfile
+			return nil
+		}
+	})
+}
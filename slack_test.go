@@ -0,0 +1,24 @@
+package dmv
+
+import "testing"
+
+func TestSlackTeamRestriction(t *testing.T) {
+	fakeProvider(t, map[string]interface{}{
+		"/token":    `{"ok":true,"access_token":"token"}`,
+		"/userInfo": `{"ok":true,"sub":"U1","https://slack.com/user_id":"U1","https://slack.com/team_id":"T1","email":"gopher@example.com"}`,
+	}, map[*string]string{&slackTokenURL: "/token", &slackProfileURL: "/userInfo"})
+
+	var sl *Slack
+	serveCallback("slack", AuthSlack(&SlackOptions{OAuth2Options: testOAuth2Options("slack"), TeamID: "T1"}), func(s *Slack) { sl = s })
+	if len(sl.Errors) > 0 {
+		t.Fatal(sl.Errors)
+	}
+	if sl.Profile.UserID != "U1" || sl.Profile.TeamID != "T1" {
+		t.Errorf("Profile not mapped, got %+v", sl.Profile)
+	}
+
+	serveCallback("slack", AuthSlack(&SlackOptions{OAuth2Options: testOAuth2Options("slack"), TeamID: "T2"}), func(s *Slack) { sl = s })
+	if len(sl.Errors) == 0 {
+		t.Error("Team T1 should not be allowed")
+	}
+}
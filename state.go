@@ -0,0 +1,117 @@
+package dmv
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// setOAuthState generates a random state for the authorization request and
+// stores it, with the return_to parameter of r, in a short lived cookie
+// scoped to the callback path. If formPost is true the cookie is sent on
+// cross-site POST requests, which providers using response_mode=form_post
+// need. stepUp records that additional scopes were requested for the signed
+// in user.
+func setOAuthState(w http.ResponseWriter, r *http.Request, name, callbackURL string, formPost, stepUp bool) string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	state := base64.RawURLEncoding.EncodeToString(b)
+	v := url.Values{"state": {state}}
+	if returnTo := r.FormValue("return_to"); returnTo != "" {
+		v.Set("return_to", returnTo)
+	}
+	if stepUp {
+		v.Set("step_up", "1")
+	}
+	u, _ := url.Parse(callbackURL)
+	cookie := &http.Cookie{
+		Name:     "dmv_state_" + name,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(v.Encode())),
+		Path:     u.Path,
+		MaxAge:   600,
+		Secure:   u.Scheme == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if formPost && cookie.Secure {
+		cookie.SameSite = http.SameSiteNoneMode
+	}
+	http.SetCookie(w, cookie)
+	return state
+}
+
+// oauthState returns the state, return_to and step-up flag stored by
+// setOAuthState and clears the cookie.
+func oauthState(w http.ResponseWriter, r *http.Request, name string) (state, returnTo string, stepUp bool) {
+	cookie, err := r.Cookie("dmv_state_" + name)
+	if err != nil {
+		return "", "", false
+	}
+	http.SetCookie(w, &http.Cookie{Name: cookie.Name, Path: r.URL.Path, MaxAge: -1})
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return "", "", false
+	}
+	v, err := url.ParseQuery(string(data))
+	if err != nil {
+		return "", "", false
+	}
+	return v.Get("state"), v.Get("return_to"), v.Get("step_up") == "1"
+}
+
+// verifyOAuthState checks the state parameter of the callback request
+// against the cookie set by setOAuthState and returns the validated
+// return_to URL, or "" if there was none or it is not allowed, and whether
+// the login was a step-up.
+func verifyOAuthState(w http.ResponseWriter, r *http.Request, name string, hosts []string) (string, bool, error) {
+	state, returnTo, stepUp := oauthState(w, r, name)
+	if state == "" {
+		return "", false, errors.New("state cookie not found or expired")
+	}
+	if subtle.ConstantTimeCompare([]byte(state), []byte(r.FormValue("state"))) != 1 {
+		return "", false, errors.New("state does not match")
+	}
+	return validReturnTo(returnTo, r, hosts), stepUp, nil
+}
+
+// validReturnTo returns returnTo if it is a local path, or an absolute
+// http(s) URL for the host of r or one of hosts, and "" otherwise, so it can
+// not be used as an open redirect.
+func validReturnTo(returnTo string, r *http.Request, hosts []string) string {
+	if returnTo == "" || strings.ContainsAny(returnTo, "\\") {
+		return ""
+	}
+	for _, c := range returnTo {
+		if c < 0x20 || c == 0x7f {
+			return ""
+		}
+	}
+	u, err := url.Parse(returnTo)
+	if err != nil || u.User != nil {
+		return ""
+	}
+	if u.Scheme == "" && u.Host == "" {
+		if !strings.HasPrefix(returnTo, "/") || strings.HasPrefix(returnTo, "//") {
+			return ""
+		}
+		return returnTo
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return ""
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return returnTo
+	}
+	for _, host := range hosts {
+		if strings.EqualFold(u.Host, host) {
+			return returnTo
+		}
+	}
+	return ""
+}
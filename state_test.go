@@ -0,0 +1,100 @@
+package dmv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// addOAuthState adds a state parameter and the cookie a login request would
+// have set to the callback request r.
+func addOAuthState(r *http.Request, name string) {
+	res := httptest.NewRecorder()
+	login, _ := http.NewRequest("GET", "/login", nil)
+	state := setOAuthState(res, login, name, "http://localhost/", false, false)
+	r.AddCookie(res.Result().Cookies()[0])
+	q := r.URL.Query()
+	q.Set("state", state)
+	r.URL.RawQuery = q.Encode()
+}
+
+func Test_OAuthStateReturnTo(t *testing.T) {
+	fakeProvider(t, map[string]interface{}{
+		"/token":     `{"access_token":"token"}`,
+		"/users/@me": `{"id":"80351110224678912","username":"gopher"}`,
+	}, map[*string]string{&discordTokenURL: "/token", &discordProfileURL: "/users/@me"})
+
+	discordOpts := &DiscordOptions{OAuth2Options: testOAuth2Options("discord")}
+	discordOpts.ReturnToHosts = []string{"docs.example.com"}
+	m := testMartini()
+	m.Get("/auth/discord", AuthDiscord(discordOpts))
+	m.Get("/auth/callback/discord", AuthDiscord(discordOpts), func(id *Identity) string {
+		if len(id.Errors) > 0 {
+			return id.Errors[0].Error()
+		}
+		return "ok " + id.ReturnTo
+	})
+	for _, tt := range []struct {
+		returnTo, want string
+		tamper         bool
+	}{
+		{"/private?page=2", "ok /private?page=2", false},
+		{"https://docs.example.com/guide", "ok https://docs.example.com/guide", false},
+		{"https://evil.example.com/", "ok ", false},
+		{"//evil.example.com/", "ok ", false},
+		{"/private", "state does not match", true},
+	} {
+		res := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", "/auth/discord?return_to="+url.QueryEscape(tt.returnTo), nil)
+		m.ServeHTTP(res, r)
+		loc, _ := url.Parse(res.Header().Get("Location"))
+		state := loc.Query().Get("state")
+		if state == "" {
+			t.Fatalf("expected state in %s", loc)
+		}
+		if tt.tamper {
+			state += "x"
+		}
+		res2 := httptest.NewRecorder()
+		cb, _ := http.NewRequest("GET", "/auth/callback/discord?code=c0d3&state="+state, nil)
+		for _, c := range res.Result().Cookies() {
+			cb.AddCookie(c)
+		}
+		m.ServeHTTP(res2, cb)
+		if res2.Body.String() != tt.want {
+			t.Errorf("return_to %q: got %q, want %q", tt.returnTo, res2.Body.String(), tt.want)
+		}
+	}
+
+	res := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/callback/discord?code=c0d3&state=abc", nil)
+	m.ServeHTTP(res, r)
+	if res.Body.String() != "state cookie not found or expired" {
+		t.Errorf("expected missing cookie error, got %q", res.Body.String())
+	}
+}
+
+func Test_ValidReturnTo(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://app.example.com/auth/callback", nil)
+	hosts := []string{"docs.example.com"}
+	for returnTo, ok := range map[string]bool{
+		"/":                                true,
+		"/a/b?c=d#e":                       true,
+		"http://app.example.com/x":         true,
+		"https://DOCS.example.com/x":       true,
+		"":                                 false,
+		"relative":                         false,
+		"//evil.com":                       false,
+		"/\\evil.com":                      false,
+		"https://evil.com":                 false,
+		"https://app.example.com@evil.com": false,
+		"javascript:alert(1)":              false,
+		"/x\r\nLocation: https://evil.com": false,
+	} {
+		got := validReturnTo(returnTo, r, hosts)
+		if (got != "") != ok {
+			t.Errorf("validReturnTo(%q) = %q, want ok=%v", returnTo, got, ok)
+		}
+	}
+}
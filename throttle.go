@@ -0,0 +1,264 @@
+package dmv
+
+import (
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LockedError is added to Local.Errors, instead of verifying the password,
+// when too many logins for the username or from the client IP have failed.
+type LockedError struct {
+	// RetryAfter is how long until another attempt is allowed.
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return "too many failed login attempts, retry in " + e.RetryAfter.String()
+}
+
+// ThrottleStore keeps counts of consecutive failed logins. Keys are
+// prefixed with "user:" or "ip:". Reserve must be atomic for each key, so
+// concurrent attempts cannot all pass the check before any is recorded.
+type ThrottleStore interface {
+	// Reserve records an attempt for key at now as a failure, unless wait
+	// returns a positive delay for the current number of failures and the
+	// time of the most recent one. The delay is returned and nothing is
+	// recorded then. If the most recent failure was longer than window ago
+	// the count restarts.
+	Reserve(key string, now time.Time, window time.Duration, wait func(failures int, last time.Time) time.Duration) (time.Duration, error)
+	// Release removes one failure recorded by Reserve for key.
+	Release(key string) error
+	// Reset forgets the failures for key.
+	Reset(key string) error
+}
+
+// ThrottleOptions are used to pass conditional arguments to NewThrottle.
+type ThrottleOptions struct {
+	// Store keeps the failure counts. Defaults to a MemoryThrottleStore,
+	// which needs replacing when running more than one process.
+	Store ThrottleStore
+	// The number of failures for a username before attempts are delayed.
+	// Defaults to 3.
+	UserAttempts int
+	// The number of failures from an IP before attempts are delayed. This
+	// should be higher than UserAttempts as many users may share an IP.
+	// Defaults to 20.
+	IPAttempts int
+	// The delay after the first failure over the limit, doubled with each
+	// further failure. Defaults to one second.
+	BaseDelay time.Duration
+	// The longest delay, which is how long a username or IP is locked out
+	// once it has failed repeatedly. Defaults to 15 minutes.
+	MaxDelay time.Duration
+	// How long after the last failure the count is forgotten. Defaults to
+	// one hour.
+	Window time.Duration
+	// The IP addresses or CIDRs of reverse proxies whose Forwarded and
+	// X-Forwarded-For headers are trusted to report the client IP. See
+	// Throttle.ClientIP.
+	TrustedProxies []string
+}
+
+// Throttle limits failed logins per username and per client IP with
+// exponential backoff. It is used by setting LocalOptions.Throttle or
+// BasicOptions.Throttle.
+type Throttle struct {
+	opts    ThrottleOptions
+	now     func() time.Time
+	proxies []*net.IPNet
+	// err is the error parsing TrustedProxies, returned by Check.
+	err error
+}
+
+// NewThrottle returns a Throttle using opts, which may be nil for the
+// defaults.
+//
+//    throttle := dmv.NewThrottle(&dmv.ThrottleOptions{UserAttempts: 5})
+//    m.Post("/login", dmv.AuthLocal(&dmv.LocalOptions{Store: store, Throttle: throttle}), func(l *dmv.Local, w http.ResponseWriter) {
+//        for _, err := range l.Errors {
+//            if _, ok := err.(*dmv.LockedError); ok {
+//                // Retry-After has been set.
+//                http.Error(w, "Too many attempts", http.StatusTooManyRequests)
+//                return
+//            }
+//        }
+//    })
+func NewThrottle(opts *ThrottleOptions) *Throttle {
+	t := &Throttle{now: time.Now}
+	if opts != nil {
+		t.opts = *opts
+	}
+	if t.opts.Store == nil {
+		t.opts.Store = NewMemoryThrottleStore()
+	}
+	if t.opts.UserAttempts == 0 {
+		t.opts.UserAttempts = 3
+	}
+	if t.opts.IPAttempts == 0 {
+		t.opts.IPAttempts = 20
+	}
+	if t.opts.BaseDelay == 0 {
+		t.opts.BaseDelay = time.Second
+	}
+	if t.opts.MaxDelay == 0 {
+		t.opts.MaxDelay = 15 * time.Minute
+	}
+	if t.opts.Window == 0 {
+		t.opts.Window = time.Hour
+	}
+	t.proxies, t.err = parseProxies(t.opts.TrustedProxies)
+	return t
+}
+
+// Check returns how long the client must wait before attempting to log in
+// as username, or zero if an attempt is allowed now. An allowed attempt is
+// recorded as a failure of the username and IP until Succeed is called, so
+// concurrent attempts cannot pass the limits. Check returns an error if
+// TrustedProxies are not valid.
+func (t *Throttle) Check(username, ip string) (time.Duration, error) {
+	if t.err != nil {
+		return 0, t.err
+	}
+	now := t.now()
+	wait, err := t.opts.Store.Reserve("user:"+username, now, t.opts.Window, t.delay(now, t.opts.UserAttempts))
+	if err != nil || wait > 0 {
+		return wait, err
+	}
+	wait, err = t.opts.Store.Reserve("ip:"+ip, now, t.opts.Window, t.delay(now, t.opts.IPAttempts))
+	if err != nil || wait > 0 {
+		t.opts.Store.Release("user:" + username)
+		return wait, err
+	}
+	return 0, nil
+}
+
+// Succeed clears the failures for username after an attempt allowed by
+// Check succeeded. Only the attempt itself is removed from the failures
+// from the IP, otherwise an attacker could reset them by logging in to
+// their own account.
+func (t *Throttle) Succeed(username, ip string) error {
+	if err := t.opts.Store.Reset("user:" + username); err != nil {
+		return err
+	}
+	return t.opts.Store.Release("ip:" + ip)
+}
+
+// cancel removes an attempt allowed by Check that could not be verified,
+// so errors of the UserStore are not counted as failures.
+func (t *Throttle) cancel(username, ip string) {
+	t.opts.Store.Release("user:" + username)
+	t.opts.Store.Release("ip:" + ip)
+}
+
+// ClientIP returns the IP address of the client of req used by Check. If
+// req.RemoteAddr is a trusted proxy it is the last address of the
+// Forwarded or X-Forwarded-For header that is not a trusted proxy.
+func (t *Throttle) ClientIP(req *http.Request) string {
+	ip := clientIP(req)
+	hops := forwardedFor(req)
+	for i := len(hops) - 1; i >= 0 && t.trusted(ip); i-- {
+		ip = hops[i]
+	}
+	return ip
+}
+
+func (t *Throttle) trusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	return parsed != nil && containsIP(t.proxies, parsed)
+}
+
+// delay returns the wait func passed to ThrottleStore.Reserve, giving the
+// time left at now of the backoff for failures after attempts.
+func (t *Throttle) delay(now time.Time, attempts int) func(int, time.Time) time.Duration {
+	return func(failures int, last time.Time) time.Duration {
+		if failures < attempts {
+			return 0
+		}
+		delay := t.opts.MaxDelay
+		if shift := uint(failures - attempts); shift < 32 && t.opts.BaseDelay<<shift < delay {
+			delay = t.opts.BaseDelay << shift
+		}
+		return last.Add(delay).Sub(now)
+	}
+}
+
+// setRetryAfter sets the Retry-After header to wait rounded up to seconds.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	secs := int64((wait + time.Second - 1) / time.Second)
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+}
+
+// clientIP returns the IP address of the client from req.RemoteAddr.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+// MemoryThrottleStore is a ThrottleStore that keeps counts in memory. It is
+// safe for concurrent use.
+type MemoryThrottleStore struct {
+	mu        sync.Mutex
+	entries   map[string]throttleEntry
+	lastPurge time.Time
+}
+
+type throttleEntry struct {
+	failures int
+	last     time.Time
+}
+
+// NewMemoryThrottleStore returns an empty MemoryThrottleStore.
+func NewMemoryThrottleStore() *MemoryThrottleStore {
+	return &MemoryThrottleStore{entries: make(map[string]throttleEntry)}
+}
+
+// Reserve implements ThrottleStore. Entries older than window are purged
+// at most once per window.
+func (s *MemoryThrottleStore) Reserve(key string, now time.Time, window time.Duration, wait func(int, time.Time) time.Duration) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if now.Sub(s.lastPurge) > window {
+		s.lastPurge = now
+		for k, e := range s.entries {
+			if now.Sub(e.last) > window {
+				delete(s.entries, k)
+			}
+		}
+	}
+	e := s.entries[key]
+	if now.Sub(e.last) > window {
+		e.failures = 0
+	}
+	if d := wait(e.failures, e.last); d > 0 {
+		return d, nil
+	}
+	e.failures++
+	e.last = now
+	s.entries[key] = e
+	return 0, nil
+}
+
+// Release implements ThrottleStore.
+func (s *MemoryThrottleStore) Release(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok && e.failures > 0 {
+		e.failures--
+		s.entries[key] = e
+	}
+	return nil
+}
+
+// Reset implements ThrottleStore.
+func (s *MemoryThrottleStore) Reset(key string) error {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.mu.Unlock()
+	return nil
+}
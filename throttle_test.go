@@ -0,0 +1,189 @@
+package dmv
+
+import (
+	"bytes"
+	"encoding/base64"
+	"github.com/go-martini/martini"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func Test_ThrottleBackoff(t *testing.T) {
+	now := time.Unix(1000000, 0)
+	th := NewThrottle(&ThrottleOptions{UserAttempts: 2, BaseDelay: time.Second, MaxDelay: 4 * time.Second})
+	th.now = func() time.Time { return now }
+	for i, want := range []time.Duration{0, 0, time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		wait, _ := th.Check("gopher", "10.0.0.1")
+		if wait != want {
+			t.Errorf("after %d failures: got wait %s, want %s", i, wait, want)
+		}
+		if wait > 0 {
+			now = now.Add(wait)
+			if wait, _ := th.Check("gopher", "10.0.0.1"); wait != 0 {
+				t.Errorf("after %d failures: got wait %s after waiting", i, wait)
+			}
+		}
+	}
+	if wait, _ := th.Check("other", "10.0.0.2"); wait != 0 {
+		t.Errorf("expected other user to be allowed, got wait %s", wait)
+	}
+	now = now.Add(3 * time.Second)
+	if wait, _ := th.Check("gopher", "10.0.0.1"); wait != time.Second {
+		t.Errorf("expected 1s remaining, got %s", wait)
+	}
+	now = now.Add(time.Second)
+	th.Check("gopher", "10.0.0.1")
+	th.Succeed("gopher", "10.0.0.1")
+	if wait, _ := th.Check("gopher", "10.0.0.1"); wait != 0 {
+		t.Errorf("expected success to reset user, got wait %s", wait)
+	}
+	now = now.Add(2 * time.Hour)
+	th.Check("gopher", "10.0.0.1")
+	if n := th.opts.Store.(*MemoryThrottleStore).entries["user:gopher"].failures; n != 1 {
+		t.Errorf("expected failures to be forgotten after window, got %d", n)
+	}
+}
+
+func Test_ThrottleIP(t *testing.T) {
+	th := NewThrottle(&ThrottleOptions{IPAttempts: 3})
+	th.Check("a", "10.0.0.1")
+	th.Check("b", "10.0.0.1")
+	for i := 0; i < 5; i++ {
+		if wait, _ := th.Check("own", "10.0.0.1"); wait != 0 {
+			t.Fatalf("expected own login to be allowed, got wait %s", wait)
+		}
+		th.Succeed("own", "10.0.0.1")
+	}
+	if wait, _ := th.Check("c", "10.0.0.1"); wait != 0 {
+		t.Error("expected successes not to count as failures")
+	}
+	if wait, _ := th.Check("d", "10.0.0.1"); wait == 0 {
+		t.Error("expected IP to be throttled")
+	}
+	if n := th.opts.Store.(*MemoryThrottleStore).entries["user:d"].failures; n != 0 {
+		t.Errorf("expected throttled attempt not to be recorded for the user, got %d", n)
+	}
+}
+
+func Test_ThrottleConcurrent(t *testing.T) {
+	th := NewThrottle(&ThrottleOptions{UserAttempts: 3})
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	allowed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if wait, _ := th.Check("gopher", "10.0.0.1"); wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 3 {
+		t.Errorf("expected 3 concurrent attempts to be allowed, got %d", allowed)
+	}
+}
+
+func Test_ThrottleClientIP(t *testing.T) {
+	th := NewThrottle(&ThrottleOptions{TrustedProxies: []string{"10.0.0.0/8"}})
+	for _, tt := range []struct {
+		remote string
+		header string
+		value  string
+		want   string
+	}{
+		{"192.0.2.1:1234", "X-Forwarded-For", "198.51.100.7", "192.0.2.1"},
+		{"10.0.0.1:1234", "", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "X-Forwarded-For", "203.0.113.9, 198.51.100.7, 10.0.0.2", "198.51.100.7"},
+		{"10.0.0.1:1234", "Forwarded", `for=203.0.113.9, for="[2001:db8::1]:4711";proto=https`, "2001:db8::1"},
+	} {
+		req, _ := http.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		if got := th.ClientIP(req); got != tt.want {
+			t.Errorf("%s %s: %q: got %q, want %q", tt.remote, tt.header, tt.value, got, tt.want)
+		}
+	}
+	if _, err := NewThrottle(&ThrottleOptions{TrustedProxies: []string{"nope"}}).Check("gopher", "10.0.0.1"); err == nil {
+		t.Error("expected an error for invalid TrustedProxies")
+	}
+}
+
+func Test_AuthLocalThrottle(t *testing.T) {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("rule"), bcrypt.MinCost)
+	store := NewMemoryUserStore()
+	store.Add("gophers", &LocalUser{ID: "1", Login: "gophers", Hash: string(hash)})
+	th := NewThrottle(&ThrottleOptions{UserAttempts: 2, BaseDelay: time.Minute})
+	m := martini.Classic()
+	m.Post("/login", AuthLocal(&LocalOptions{Store: store, Throttle: th}), func(l *Local, w http.ResponseWriter) {
+		for _, err := range l.Errors {
+			if _, ok := err.(*LockedError); ok {
+				http.Error(w, "locked", http.StatusTooManyRequests)
+				return
+			}
+		}
+		w.Write([]byte(strconv.Itoa(len(l.Errors))))
+	})
+	login := func(pass string) *httptest.ResponseRecorder {
+		data := url.Values{"username": {"gophers"}, "password": {pass}}
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/login", bytes.NewBufferString(data.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "10.0.0.1:1234"
+		m.ServeHTTP(res, req)
+		return res
+	}
+	for i := 0; i < 2; i++ {
+		if res := login("drool"); res.Body.String() != "1" {
+			t.Fatalf("attempt %d: expected invalid credentials, got %q", i, res.Body.String())
+		}
+	}
+	res := login("rule")
+	if res.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected lockout, got %d %q", res.Code, res.Body.String())
+	}
+	if res.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected Retry-After of 60, got %q", res.Header().Get("Retry-After"))
+	}
+}
+
+func Test_AuthBasicThrottle(t *testing.T) {
+	th := NewThrottle(&ThrottleOptions{UserAttempts: 1, BaseDelay: time.Minute})
+	v := VerifierFunc(func(username, password string) bool {
+		return username == "gopher" && password == "golf"
+	})
+	m := martini.Classic()
+	m.Get("/protected", AuthBasicWithOptions(&BasicOptions{Verifier: v, Throttle: th}), func(b *Basic) string {
+		return "hi " + b.Username
+	})
+	get := func(pass string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/protected", nil)
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("gopher:"+pass)))
+		req.RemoteAddr = "10.0.0.1:1234"
+		m.ServeHTTP(res, req)
+		return res
+	}
+	if res := get("golf"); res.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", res.Code)
+	}
+	if res := get("putt"); res.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", res.Code)
+	}
+	res := get("golf")
+	if res.Code != http.StatusTooManyRequests || res.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected 429 with Retry-After 60, got %d %q", res.Code, res.Header().Get("Retry-After"))
+	}
+}
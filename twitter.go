@@ -0,0 +1,161 @@
+package dmv
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/go-martini/martini"
+)
+
+var (
+	twRequestTokenURL = "https://api.twitter.com/oauth/request_token"
+	twAuthURL         = "https://api.twitter.com/oauth/authenticate"
+	twAccessTokenURL  = "https://api.twitter.com/oauth/access_token"
+	twProfileURL      = "https://api.twitter.com/1.1/account/verify_credentials.json?include_email=true&skip_status=true"
+)
+
+// Twitter stores the access token and secret along with the users profile.
+type Twitter struct {
+	Errors            []error
+	AccessToken       string
+	AccessTokenSecret string
+	Profile           TwitterProfile
+	// ReturnTo and RawProfile are as in OAuth2Result.
+	ReturnTo   string
+	RawProfile json.RawMessage
+}
+
+// TwitterProfile stores information about the user from Twitter. Email is
+// only populated if the application has permission to request it.
+type TwitterProfile struct {
+	ID              string `json:"id_str"`
+	ScreenName      string `json:"screen_name"`
+	Name            string `json:"name"`
+	Email           string `json:"email"`
+	ProfileImageURL string `json:"profile_image_url_https"`
+}
+
+func (tw *Twitter) addError(err error) {
+	tw.Errors = append(tw.Errors, err)
+}
+
+// Identity returns the user as an Identity. Email is verified when set, as
+// Twitter only returns verified addresses.
+func (tw *Twitter) Identity() *Identity {
+	return &Identity{
+		Errors:        tw.Errors,
+		ReturnTo:      tw.ReturnTo,
+		Provider:      "twitter",
+		Subject:       tw.Profile.ID,
+		Email:         tw.Profile.Email,
+		EmailVerified: tw.Profile.Email != "",
+		Name:          tw.Profile.Name,
+		AvatarURL:     tw.Profile.ProfileImageURL,
+		Claims:        profileClaims(tw.RawProfile, tw.Profile),
+	}
+}
+
+// AuthTwitter authenticates users using Twitter and OAuth 1.0a. The request
+// token secret is kept in a short lived cookie between the login and
+// callback requests. After handling a callback request, a request is made to
+// get the users Twitter profile and a Twitter struct will be mapped to the
+// current request context.
+//
+// This function should be called twice in each application, once on the login
+// handler and once on the callback handler.
+//
+//     twOpts := &dmv.OAuth1Options{
+//         ConsumerKey:    "consumer_key",
+//         ConsumerSecret: "consumer_secret",
+//         CallbackURL:    "http://host:port/auth/callback/twitter",
+//     }
+//
+//     m.Get("/auth/twitter", dmv.AuthTwitter(twOpts))
+//     m.Get("/auth/callback/twitter", dmv.AuthTwitter(twOpts), func(tw *dmv.Twitter, req *http.Request, w http.ResponseWriter) {
+//         // Handle any errors.
+//         if len(tw.Errors) > 0 {
+//             http.Error(w, "OAuth failure", http.StatusInternalServerError)
+//             return
+//         }
+//         // Do something in a database to create or find the user by the Twitter profile id.
+//         user := findOrCreateByTwitterID(tw.Profile.ID)
+//         s.Set("userID", user.ID)
+//         http.Redirect(w, req, "/", http.StatusFound)
+//     })
+func AuthTwitter(opts *OAuth1Options) martini.Handler {
+	handler, _ := newTwitter(opts)
+	return handler
+}
+
+// NewAuthTwitter is like AuthTwitter, but returns an error if opts are not
+// valid.
+func NewAuthTwitter(opts *OAuth1Options) (martini.Handler, error) {
+	handler, err := newTwitter(opts)
+	if err != nil {
+		return nil, err
+	}
+	return handler, nil
+}
+
+func newTwitter(opts *OAuth1Options) (martini.Handler, error) {
+	o := *opts
+	opts = &o
+	opts.RequestTokenURL = twRequestTokenURL
+	opts.AuthURL = twAuthURL
+	opts.AccessTokenURL = twAccessTokenURL
+	h := newOAuth1Handler(opts)
+
+	return func(r *http.Request, w http.ResponseWriter, c martini.Context) {
+		transport := h.transport(r)
+		if opts.CallbackFunc != nil && transport.Config.CallbackURL == "" {
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		cbPath := ""
+		if u, err := url.Parse(transport.Config.CallbackURL); err == nil {
+			cbPath = u.Path
+		}
+		if r.URL.Path != cbPath {
+			rt, err := transport.RequestToken()
+			if err != nil {
+				http.Error(w, "Unable to get request token", http.StatusBadGateway)
+				return
+			}
+			setRequestToken(w, "dmv_twitter", transport.Config.CallbackURL, rt)
+			setOAuthState(w, r, "twitter", transport.Config.CallbackURL, false, false)
+			http.Redirect(w, r, transport.Config.AuthorizeURL(rt), http.StatusFound)
+			return
+		}
+		tw := &Twitter{}
+		defer mapWithIdentity(c, tw)
+		if r.FormValue("denied") != "" {
+			tw.Errors = append(tw.Errors, errors.New("user denied access"))
+			return
+		}
+		// The request token takes the place of state in OAuth 1.0a.
+		_, returnTo, _ := oauthState(w, r, "twitter")
+		tw.ReturnTo = validReturnTo(returnTo, r, opts.ReturnToHosts)
+		rt := requestToken(w, r, "dmv_twitter")
+		if rt == nil || rt.Token != r.FormValue("oauth_token") {
+			tw.Errors = append(tw.Errors, errors.New("request token not found or does not match"))
+			return
+		}
+		tk, err := transport.Exchange(rt, r.FormValue("oauth_verifier"))
+		if err != nil {
+			tw.Errors = append(tw.Errors, err)
+			return
+		}
+		tw.AccessToken = tk.Token
+		tw.AccessTokenSecret = tk.Secret
+		profile := TwitterProfile{}
+		tw.RawProfile, err = getProfile(transport.Client(), twProfileURL, &profile)
+		if err != nil {
+			tw.Errors = append(tw.Errors, err)
+			return
+		}
+		tw.Profile = profile
+		return
+	}, opts.Validate()
+}
@@ -0,0 +1,56 @@
+package dmv
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTwitterThreeLegged(t *testing.T) {
+	fakeProvider(t, map[string]interface{}{
+		"/request_token":           "oauth_token=rt&oauth_token_secret=rts&oauth_callback_confirmed=true",
+		"/access_token":            "oauth_token=at&oauth_token_secret=ats",
+		"/verify_credentials.json": `{"id_str":"12","screen_name":"gopher"}`,
+	}, map[*string]string{&twRequestTokenURL: "/request_token", &twAccessTokenURL: "/access_token", &twProfileURL: "/verify_credentials.json"})
+
+	twOpts := &OAuth1Options{
+		ConsumerKey:    "consumer_key",
+		ConsumerSecret: "consumer_secret",
+		CallbackFunc:   RedirectRelativeFunc("/auth/callback/twitter"),
+	}
+	var tw *Twitter
+	m := testMartini()
+	m.Get("/auth/twitter", AuthTwitter(twOpts))
+	m.Get("/auth/callback/twitter", AuthTwitter(twOpts), func(tt *Twitter) {
+		tw = tt
+	})
+
+	res := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/auth/twitter", nil)
+	m.ServeHTTP(res, r)
+	if location := res.HeaderMap.Get("Location"); !strings.HasSuffix(location, "?oauth_token=rt") {
+		t.Errorf("Not being redirected to the right page, %v found", location)
+	}
+	cookie := res.HeaderMap.Get("Set-Cookie")
+	if !strings.HasPrefix(cookie, "dmv_twitter=rt:rts") {
+		t.Fatalf("Request token cookie not set, got %q", cookie)
+	}
+
+	r, _ = http.NewRequest("GET", "/auth/callback/twitter?oauth_token=rt&oauth_verifier=v", nil)
+	r.Header.Set("Cookie", "dmv_twitter=rt:rts")
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if len(tw.Errors) > 0 {
+		t.Fatal(tw.Errors)
+	}
+	if tw.AccessToken != "at" || tw.AccessTokenSecret != "ats" || tw.Profile.ScreenName != "gopher" {
+		t.Errorf("Twitter not mapped, got %+v", tw)
+	}
+
+	r, _ = http.NewRequest("GET", "/auth/callback/twitter?oauth_token=other&oauth_verifier=v", nil)
+	r.Header.Set("Cookie", "dmv_twitter=rt:rts")
+	m.ServeHTTP(httptest.NewRecorder(), r)
+	if len(tw.Errors) == 0 {
+		t.Error("Mismatched request token should fail")
+	}
+}
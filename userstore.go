@@ -0,0 +1,168 @@
+package dmv
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+)
+
+// dummyHash is compared against when a user is not found, so unknown users
+// take as long to reject as known ones.
+const dummyHash = "$2a$10$4/XBb0yECXPM2GyM/CFLsOOl64nthYErR9ZMvLHttseD4DOZNiZ4u"
+
+// dummyPassword is hashed to make a dummy hash for a password.Policy.
+const dummyPassword = "dmv dummy password"
+
+var (
+	// ErrUserNotFound is returned by a UserStore when no user has the
+	// login.
+	ErrUserNotFound = errors.New("user not found")
+	// ErrInvalidCredentials is added to Local.Errors when the user does not
+	// exist or the password does not match.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// User is a user returned from a UserStore.
+type User interface {
+	// PasswordHash returns the stored hash of the users password.
+	PasswordHash() string
+}
+
+// UserStore finds users for AuthLocal to verify.
+type UserStore interface {
+	// FindByLogin returns the user with the login, usually a username or
+	// email address. It returns ErrUserNotFound if there is no such user.
+	FindByLogin(login string) (User, error)
+}
+
+// HashUpdater may be implemented by a UserStore to have AuthLocal replace
+// password hashes made with outdated algorithms or parameters. It is called
+// with a new hash after a successful login.
+type HashUpdater interface {
+	UpdatePasswordHash(u User, hash string) error
+}
+
+// LocalUser is a simple User with an ID, login and password hash.
+// It is returned by SQLUserStore.
+type LocalUser struct {
+	ID    string
+	Login string
+	Hash  string
+}
+
+// PasswordHash returns u.Hash.
+func (u *LocalUser) PasswordHash() string {
+	return u.Hash
+}
+
+// MemoryUserStore is a UserStore that keeps users in memory. It is safe for
+// concurrent use and mostly useful for tests and small applications.
+// A *LocalUser is copied when added and found, so updating its hash does
+// not change users already returned.
+type MemoryUserStore struct {
+	mu    sync.RWMutex
+	users map[string]User
+}
+
+// NewMemoryUserStore returns an empty MemoryUserStore.
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: make(map[string]User)}
+}
+
+// Add adds or replaces the user with login. The Login of a *LocalUser is
+// set to login.
+func (s *MemoryUserStore) Add(login string, u User) {
+	if lu, ok := u.(*LocalUser); ok {
+		c := *lu
+		c.Login = login
+		u = &c
+	}
+	s.mu.Lock()
+	s.users[login] = u
+	s.mu.Unlock()
+}
+
+// FindByLogin implements UserStore.
+func (s *MemoryUserStore) FindByLogin(login string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[login]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	if lu, ok := u.(*LocalUser); ok {
+		c := *lu
+		return &c, nil
+	}
+	return u, nil
+}
+
+// UpdatePasswordHash implements HashUpdater for users that are a
+// *LocalUser, replacing the stored user with its Login and ID. Other users
+// are left unchanged.
+func (s *MemoryUserStore) UpdatePasswordHash(u User, hash string) error {
+	lu, ok := u.(*LocalUser)
+	if !ok {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stored, ok := s.users[lu.Login].(*LocalUser); ok && stored.ID == lu.ID {
+		c := *stored
+		c.Hash = hash
+		s.users[lu.Login] = &c
+	}
+	return nil
+}
+
+// SQLUserStore is a UserStore backed by a database/sql database. Query must
+// select the id and password hash of the user with the login passed as the
+// only argument. It defaults to
+//
+//     SELECT id, password_hash FROM users WHERE login = ?
+//
+// which needs changing for drivers using other placeholders, such as $1.
+// UpdateQuery must set the password hash, passed as the first argument, of
+// the user with the id passed as the second. It defaults to
+//
+//     UPDATE users SET password_hash = ? WHERE id = ?
+type SQLUserStore struct {
+	DB          *sql.DB
+	Query       string
+	UpdateQuery string
+}
+
+// FindByLogin implements UserStore and returns a *LocalUser.
+func (s *SQLUserStore) FindByLogin(login string) (User, error) {
+	query := s.Query
+	if query == "" {
+		query = "SELECT id, password_hash FROM users WHERE login = ?"
+	}
+	u := &LocalUser{Login: login}
+	err := s.DB.QueryRow(query, login).Scan(&u.ID, &u.Hash)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// UpdatePasswordHash implements HashUpdater for a *LocalUser returned by
+// FindByLogin.
+func (s *SQLUserStore) UpdatePasswordHash(u User, hash string) error {
+	lu, ok := u.(*LocalUser)
+	if !ok {
+		return errors.New("SQLUserStore can only update a *LocalUser")
+	}
+	query := s.UpdateQuery
+	if query == "" {
+		query = "UPDATE users SET password_hash = ? WHERE id = ?"
+	}
+	if _, err := s.DB.Exec(query, hash, lu.ID); err != nil {
+		return err
+	}
+	lu.Hash = hash
+	return nil
+}